@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archive formats of export and import
+const (
+	formatRaw = "raw"
+	formatTar = "tar"
+)
+
+// archive compressions, compressing and decompressing is done on the node
+const (
+	compressionNone = "none"
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+)
+
+var (
+	compressCommands = map[string]string{
+		compressionNone: "",
+		compressionGzip: " | gzip -c",
+		compressionZstd: " | zstd -c -q",
+	}
+	decompressCommands = map[string]string{
+		compressionNone: "",
+		compressionGzip: "gzip -dc | ",
+		compressionZstd: "zstd -dc -q | ",
+	}
+)
+
+// archiveType returns format and compression matching the name of the archive,
+// e.g. raw and gzip for db.img.gz, the format is empty if it is unknown
+func archiveType(file string) (string, string) {
+	compression := compressionNone
+	switch {
+	case strings.HasSuffix(file, ".gz"):
+		compression = compressionGzip
+	case strings.HasSuffix(file, ".zst"):
+		compression = compressionZstd
+	}
+	name := strings.TrimSuffix(strings.TrimSuffix(file, ".gz"), ".zst")
+	switch {
+	case strings.HasSuffix(name, ".tar"):
+		return formatTar, compression
+	case strings.HasSuffix(name, ".img"), strings.HasSuffix(name, ".raw"):
+		return formatRaw, compression
+	}
+	return "", compression
+}
+
+// checkArchiveType validates format and compression given on the command line
+func checkArchiveType(format string, compression string) error {
+	if format != formatRaw && format != formatTar {
+		return fmt.Errorf("unknown format %q, must be raw or tar", format)
+	}
+	if _, ok := compressCommands[compression]; !ok {
+		return fmt.Errorf("unknown compression %q, must be none, gzip or zstd", compression)
+	}
+	return nil
+}
+
+// checksumFile returns the name of the sha256sum compatible sidecar of the archive
+func checksumFile(file string) string {
+	return file + ".sha256"
+}
+
+// fileChecksum returns the hex encoded sha256 of the file
+func fileChecksum(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", fmt.Errorf("unable to read %s: %v", file, err)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// writeChecksum writes the checksum sidecar of the archive
+func writeChecksum(file string) error {
+	sum, err := fileChecksum(file)
+	if err != nil {
+		return err
+	}
+	content := fmt.Sprintf("%s  %s\n", sum, filepath.Base(file))
+	return ioutil.WriteFile(checksumFile(file), []byte(content), 0644)
+}
+
+// verifyChecksum compares the archive with its checksum sidecar
+func verifyChecksum(file string) error {
+	content, err := ioutil.ReadFile(checksumFile(file))
+	if err != nil {
+		return fmt.Errorf("unable to read checksum: %v", err)
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) < 1 {
+		return fmt.Errorf("checksum file %s is empty", checksumFile(file))
+	}
+	sum, err := fileChecksum(file)
+	if err != nil {
+		return err
+	}
+	if sum != fields[0] {
+		return fmt.Errorf("checksum of %s is %s, expected %s", file, sum, fields[0])
+	}
+	return nil
+}
+
+// progress counts transferred bytes and reports them regularly
+type progress struct {
+	done     uint64
+	total    uint64
+	interval time.Duration
+	last     time.Time
+}
+
+func (p *progress) add(n int) {
+	p.done += uint64(n)
+	if time.Since(p.last) >= p.interval {
+		p.last = time.Now()
+		p.report()
+	}
+}
+
+func (p *progress) report() {
+	if p.total > 0 {
+		fmt.Printf("%s of about %s transferred\n", formatBytes(p.done), formatBytes(p.total))
+		return
+	}
+	fmt.Printf("%s transferred\n", formatBytes(p.done))
+}
+
+// progressWriter reports the bytes written
+type progressWriter struct {
+	progress
+	w io.Writer
+}
+
+func newProgressWriter(w io.Writer, total uint64, interval time.Duration) *progressWriter {
+	return &progressWriter{w: w, progress: progress{total: total, interval: interval, last: time.Now()}}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.add(n)
+	return n, err
+}
+
+// progressReader reports the bytes read
+type progressReader struct {
+	progress
+	r io.Reader
+}
+
+func newProgressReader(r io.Reader, total uint64, interval time.Duration) *progressReader {
+	return &progressReader{r: r, progress: progress{total: total, interval: interval, last: time.Now()}}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.add(n)
+	return n, err
+}
@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	capacityCmd = &cobra.Command{
+		Use:    "capacity",
+		Short:  "show the capacity of the volume group per node and cluster-wide",
+		Long:   "show size, free space, physical volumes and allocation per volume type of the volume group on every node and the totals of the cluster",
+		PreRun: bindPFlags,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return capacity()
+		},
+	}
+)
+
+func init() {
+	capacityCmd.Flags().StringSlice("node", nil, "nodes to report, default is all nodes with lvm volumes")
+	capacityCmd.Flags().Float64("min-free-percent", 10, "highlight nodes with less free space in percent of the volume group size")
+	capacityCmd.Flags().StringP("output", "o", "table", "output format, table or json")
+}
+
+// nodeCapacity is the capacity of the volume group on a node, sizes are in bytes
+type nodeCapacity struct {
+	Node    string   `json:"node"`
+	VG      string   `json:"vg"`
+	Size    uint64   `json:"size"`
+	Free    uint64   `json:"free"`
+	Devices []string `json:"devices"`
+	// Allocated is the space used on the physical volumes per volume type,
+	// mirrors count with all their legs
+	Allocated map[string]uint64 `json:"allocated"`
+	ThinPools []thinPool        `json:"thinPools,omitempty"`
+	LowFree   bool              `json:"lowFree"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// capacityReport is the capacity of all nodes and their totals
+type capacityReport struct {
+	Nodes []nodeCapacity `json:"nodes"`
+	Total nodeCapacity   `json:"total"`
+}
+
+func capacity() error {
+	output := viper.GetString("output")
+	if output != "table" && output != "json" {
+		return fmt.Errorf("unknown output format %q, must be table or json", output)
+	}
+
+	// with --node and the local executor the API server is not needed
+	clientset, config, namespace, err := newClientset()
+	if err != nil && !(apiUnavailable(err) && len(viper.GetStringSlice("node")) > 0) {
+		return err
+	}
+	nodes, err := lvmNodes(clientset)
+	if err != nil {
+		return err
+	}
+
+	report := capacityReport{
+		Total: nodeCapacity{
+			Node:      "TOTAL",
+			VG:        "-",
+			Allocated: map[string]uint64{},
+		},
+	}
+	for _, node := range nodes {
+		c := nodeCapacity{
+			Node:      node,
+			Allocated: map[string]uint64{},
+		}
+		err := withExecutor(clientset, config, node, namespace, "capacity", func(e executor.Executor) error {
+			c.VG = driverVG(node)
+			return nodeVGCapacity(e, &c)
+		})
+		if err != nil {
+			c.Error = err.Error()
+		}
+		c.LowFree = c.Error == "" && percent(c.Free, c.Size) < viper.GetFloat64("min-free-percent")
+
+		report.Total.Size += c.Size
+		report.Total.Free += c.Free
+		for t, size := range c.Allocated {
+			report.Total.Allocated[t] += size
+		}
+		report.Nodes = append(report.Nodes, c)
+	}
+	report.Total.LowFree = percent(report.Total.Free, report.Total.Size) < viper.GetFloat64("min-free-percent")
+
+	if output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	printCapacity(report)
+	return nil
+}
+
+// nodeVGCapacity fills in the capacity of the volume group on the node of the executor
+func nodeVGCapacity(e executor.Executor, c *nodeCapacity) error {
+	vg, err := lvm.GetVG(e, c.VG)
+	if err != nil {
+		return err
+	}
+	if vg == nil {
+		return fmt.Errorf("volume group %s not found", c.VG)
+	}
+	c.Size = vg.Size
+	c.Free = vg.Free
+
+	pvs, err := lvm.ListVGPVs(e, c.VG)
+	if err != nil {
+		return err
+	}
+	for _, pv := range pvs {
+		c.Devices = append(c.Devices, pv.Name)
+	}
+
+	lvs, err := lvm.ListLVs(e, c.VG)
+	if err != nil {
+		return err
+	}
+	types := map[string]string{}
+	for _, lv := range lvs {
+		types[lv.Name] = lv.Type()
+	}
+	// the segments hold the extents on the physical volumes, so every leg of a
+	// mirror and the metadata of raid volumes and thin pools are counted. Thin
+	// volumes have no segments, they allocate from their pool.
+	segments, err := lvm.ListSegments(e, c.VG)
+	if err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if s.LV == "" {
+			continue
+		}
+		t, ok := types[s.LV]
+		if !ok {
+			// hidden volumes without a visible parent, e.g. the pool metadata spare
+			t = "other"
+		}
+		c.Allocated[t] += s.Extents * vg.ExtentSize
+	}
+	c.ThinPools = thinPools(lvs)
+	return nil
+}
+
+func printCapacity(report capacityReport) {
+	var types []string
+	for t := range report.Total.Allocated {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	header := []string{"NODE", "VG", "SIZE", "FREE", "FREE%"}
+	for _, t := range types {
+		header = append(header, strings.ToUpper(t))
+	}
+	header = append(header, "DEVICES")
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, c := range append(report.Nodes, report.Total) {
+		if c.Error != "" {
+			fmt.Fprintf(w, "%s\t%s\terror: %s\n", c.Node, c.VG, c.Error)
+			continue
+		}
+		free := fmt.Sprintf("%.1f", percent(c.Free, c.Size))
+		if c.LowFree {
+			free += " LOW"
+		}
+		row := []string{c.Node, c.VG, formatBytes(c.Size), formatBytes(c.Free), free}
+		for _, t := range types {
+			row = append(row, formatBytes(c.Allocated[t]))
+		}
+		row = append(row, strings.Join(c.Devices, ","))
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+	printThinPools(report)
+}
+
+func printThinPools(report capacityReport) {
+	var warnings []string
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	header := false
+	for _, c := range report.Nodes {
+		for _, p := range c.ThinPools {
+			if !header {
+				fmt.Println()
+				fmt.Fprintln(w, "NODE\tTHIN POOL\tSIZE\tDATA%\tMETA%\tVIRTUAL\tVOLUMES")
+				header = true
+			}
+			if warning := p.warning(); warning != "" {
+				warnings = append(warnings, fmt.Sprintf("node %s: %s", c.Node, warning))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%.1f\t%.1f\t%s\t%d\n", c.Node, p.Name, formatBytes(p.Size), p.DataPercent, p.MetadataPercent, formatBytes(p.Virtual), p.Volumes)
+		}
+	}
+	w.Flush()
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+}
+
+func percent(part uint64, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+	"github.com/metal-stack/csilvmctl/cmd/internal/helper"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	cleanupCmd = &cobra.Command{
+		Use:   "cleanup",
+		Short: "remove leftovers of interrupted csilvmctl runs",
+		Long: "remove migrator and mounter pods, temporary PersistentVolumeClaims, released PersistentVolumes retained by csilvmctl " +
+			"whose logical volume is gone and temporary logical volumes left behind by interrupted runs",
+		PreRun: bindPFlags,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cleanup()
+		},
+	}
+)
+
+func init() {
+	cleanupCmd.Flags().StringSlice("node", nil, "nodes to look for temporary logical volumes on, default is all nodes with lvm volumes")
+	cleanupCmd.Flags().Duration("older-than", time.Hour, "only remove leftovers created longer ago than this, so running commands are not disturbed")
+}
+
+// leftover is an object left behind by csilvmctl
+type leftover struct {
+	kind      string
+	namespace string
+	node      string
+	name      string
+	created   time.Time
+	remove    func() error
+}
+
+func cleanup() error {
+	clientset, config, namespace, err := newClientset()
+	if err != nil {
+		return err
+	}
+
+	leftovers, err := findLeftoverPods(clientset)
+	if err != nil {
+		return err
+	}
+	pvcs, err := findLeftoverClaims(clientset)
+	if err != nil {
+		return err
+	}
+	leftovers = append(leftovers, pvcs...)
+
+	retained, err := clientset.CoreV1().PersistentVolumes().List(context.TODO(), metav1.ListOptions{
+		LabelSelector: helper.RetainedLabel,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list persistent volumes: %v", err)
+	}
+
+	nodes, err := lvmNodes(clientset)
+	if err != nil {
+		return err
+	}
+	// executors are kept until the leftovers on their nodes are removed
+	executors, err := startExecutors(clientset, config, nodes, namespace, "cleanup")
+	if err != nil {
+		return err
+	}
+	defer executors.Destroy()
+	for _, node := range nodes {
+		lvs, err := findLeftoverVolumes(clientset, executors[node], node, retained.Items)
+		if err != nil {
+			return err
+		}
+		leftovers = append(leftovers, lvs...)
+	}
+
+	// objects of commands which are still running are too young
+	minAge := viper.GetDuration("older-than")
+	var old []leftover
+	for _, l := range leftovers {
+		if !l.created.IsZero() && time.Since(l.created) < minAge {
+			fmt.Printf("skipping %s %s, it was created %s ago\n", l.kind, l.name, age(l.created))
+			continue
+		}
+		old = append(old, l)
+	}
+	leftovers = old
+
+	if len(leftovers) == 0 {
+		fmt.Println("Nothing to clean up.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAMESPACE\tNODE\tNAME\tAGE")
+	for _, l := range leftovers {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", l.kind, l.namespace, l.node, l.name, age(l.created))
+	}
+	w.Flush()
+
+	if !viper.GetBool("yes") {
+		if err := helper.Prompt("Do you want to remove these objects? (y/n) ", "y"); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, l := range leftovers {
+		err := l.remove()
+		if err != nil {
+			fmt.Printf("unable to remove %s %s: %v\n", l.kind, l.name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s %s removed\n", l.kind, l.name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d objects could not be removed", failed)
+	}
+	return nil
+}
+
+// findLeftoverPods returns the pods created by csilvmctl, including the ones
+// of older versions which were recognizable by their name only
+func findLeftoverPods(clientset *kubernetes.Clientset) ([]leftover, error) {
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list pods: %v", err)
+	}
+	var result []leftover
+	for _, p := range pods.Items {
+		if p.Labels[helper.ManagedByLabel] != helper.ManagedByValue &&
+			!strings.HasPrefix(p.Name, helper.MigratorPodPrefix) &&
+			!strings.HasPrefix(p.Name, helper.MounterPodPrefix) {
+			continue
+		}
+		namespace, name := p.Namespace, p.Name
+		result = append(result, leftover{
+			kind:      "pod",
+			namespace: namespace,
+			node:      p.Spec.NodeName,
+			name:      name,
+			created:   p.CreationTimestamp.Time,
+			remove: func() error {
+				return clientset.CoreV1().Pods(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+			},
+		})
+	}
+	return result, nil
+}
+
+// findLeftoverClaims returns the temporary claims created by csilvmctl
+func findLeftoverClaims(clientset *kubernetes.Clientset) ([]leftover, error) {
+	pvcs, err := clientset.CoreV1().PersistentVolumeClaims(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.Set{helper.ManagedByLabel: helper.ManagedByValue}.String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list persistent volume claims: %v", err)
+	}
+	var result []leftover
+	for _, pvc := range pvcs.Items {
+		namespace, name := pvc.Namespace, pvc.Name
+		result = append(result, leftover{
+			kind:      "pvc",
+			namespace: namespace,
+			name:      name,
+			created:   pvc.CreationTimestamp.Time,
+			remove: func() error {
+				return clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+			},
+		})
+	}
+	return result, nil
+}
+
+// findLeftoverVolumes returns the temporary logical volumes on the node and the
+// released persistent volumes retained by csilvmctl whose logical volume is
+// gone. Retained volumes still backed by a logical volume are kept, they may
+// hold the only copy of the data.
+func findLeftoverVolumes(clientset *kubernetes.Clientset, e executor.Executor, node string, retained []v1.PersistentVolume) ([]leftover, error) {
+	lvs, err := listNodeLVs(e, node)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list logical volumes on node %s: %v", node, err)
+	}
+
+	var result []leftover
+	existing := map[string]bool{}
+	for i := range lvs {
+		lv := lvs[i]
+		existing[lv.Name] = true
+		if !lv.HasTag(lvm.TemporaryTag) {
+			continue
+		}
+		result = append(result, leftover{
+			kind:    "lv",
+			node:    node,
+			name:    lv.Path(),
+			created: lv.Time,
+			remove: func() error {
+				stdout, stderr, err := e.Exec("lvremove -y "+lv.Path(), nil)
+				if err != nil {
+					return fmt.Errorf("%v %s %s", err, stdout, stderr)
+				}
+				return nil
+			},
+		})
+	}
+
+	for i := range retained {
+		pv := retained[i]
+		if helper.VolumeNode(&pv) != node || pv.Status.Phase != v1.VolumeReleased {
+			continue
+		}
+		if existing[volumeLVName(&pv)] {
+			fmt.Printf("skipping released volume %s, its logical volume still exists on node %s\n", pv.Name, node)
+			continue
+		}
+		result = append(result, leftover{
+			kind:    "pv",
+			node:    node,
+			name:    pv.Name,
+			created: pv.CreationTimestamp.Time,
+			remove: func() error {
+				return clientset.CoreV1().PersistentVolumes().Delete(context.TODO(), pv.Name, metav1.DeleteOptions{})
+			},
+		})
+	}
+	return result, nil
+}
+
+func age(t time.Time) string {
+	if t.IsZero() {
+		return "<unknown>"
+	}
+	return duration.HumanDuration(time.Since(t))
+}
@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/helper"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/spf13/viper"
+
+	// needed for kubectl auth
+	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
+)
+
+const (
+	// legacyProvisioner is the provisioner of csi-lvm volumes
+	legacyProvisioner = "metal-stack.io/csi-lvm"
+	// provisionedByAnnotation holds the provisioner of a persistent volume
+	provisionedByAnnotation = "pv.kubernetes.io/provisioned-by"
+	// localAPITimeout limits requests to the API server with the local executor
+	localAPITimeout = 10 * time.Second
+)
+
+// localCommands are the commands working with --executor local while the API server is unreachable
+const localCommands = "lv list, health, capacity --node and vg extend"
+
+// apiUnavailableError is returned by newClientset for the local executor if
+// the API server cannot be reached
+type apiUnavailableError struct {
+	err error
+}
+
+func (e *apiUnavailableError) Error() string {
+	return fmt.Sprintf("the api server is not reachable: %v, with --executor local only %s work without it", e.err, localCommands)
+}
+
+// apiUnavailable returns true if the command may continue without the API
+// server, which is only the case with the local executor
+func apiUnavailable(err error) bool {
+	_, ok := err.(*apiUnavailableError)
+	return ok
+}
+
+// newClientset returns a clientset for the configured kubeconfig together with
+// the namespace given with --namespace or the one of the current context.
+// With the local executor the API server is checked first, if it cannot be
+// reached an apiUnavailableError is returned.
+func newClientset() (*kubernetes.Clientset, *restclient.Config, string, error) {
+	local := viper.GetString("executor") == executorLocal
+	// use the current context in kubeconfig
+	config, err := clientcmd.BuildConfigFromFlags("", viper.GetString("kubeconfig"))
+	if err != nil {
+		if local {
+			return nil, nil, "", &apiUnavailableError{err: err}
+		}
+		return nil, nil, "", err
+	}
+	if local {
+		// do not hang on an unreachable API server, the local executor works without it
+		config.Timeout = localAPITimeout
+	}
+	namespace := contextNamespace()
+	if viper.GetString("namespace") != "" {
+		namespace = viper.GetString("namespace")
+	}
+
+	// create the clientset
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if local {
+		if _, err := clientset.Discovery().ServerVersion(); err != nil {
+			return nil, config, namespace, &apiUnavailableError{err: err}
+		}
+	}
+	return clientset, config, namespace, nil
+}
+
+// localNodes returns the nodes given with --node or the name of this host,
+// they are used with the local executor when the API server is unreachable
+func localNodes() ([]string, error) {
+	if nodes := viper.GetStringSlice("node"); len(nodes) > 0 {
+		return nodes, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine local hostname: %v", err)
+	}
+	return []string{hostname}, nil
+}
+
+// contextNamespace returns the namespace of the current kubeconfig context
+func contextNamespace() string {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	configOverrides := &clientcmd.ConfigOverrides{}
+	kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
+	namespace, _, _ := kubeConfig.Namespace()
+	return namespace
+}
+
+// volumeRequest returns the request for a claim holding a copy of a logical
+// volume, the volume may be larger than the request of its claim
+func volumeRequest(request resource.Quantity, size uint64) resource.Quantity {
+	if request.Value() >= int64(size) {
+		return request
+	}
+	return *resource.NewQuantity(int64(size), resource.BinarySI)
+}
+
+// driverStorageClasses returns the names of the csi-driver-lvm storage classes per volume type
+func driverStorageClasses(clientset *kubernetes.Clientset) (map[string]string, error) {
+	scs, err := clientset.StorageV1().StorageClasses().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list storage classes: %v", err)
+	}
+	storageClasses := make(map[string]string)
+	for _, s := range scs.Items {
+		if s.Provisioner == viper.GetString("provisioner") {
+			storageClasses[s.Parameters["type"]] = s.Name
+		}
+	}
+	return storageClasses, nil
+}
+
+// checkClaimUnused returns an error if a pod uses the claim
+func checkClaimUnused(clientset *kubernetes.Clientset, namespace string, pvcName string) error {
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, p := range pods.Items {
+		for _, v := range p.Spec.Volumes {
+			if v.PersistentVolumeClaim != nil && v.PersistentVolumeClaim.ClaimName == pvcName {
+				return fmt.Errorf("error: pvc %s is in use by pod %s", pvcName, p.GetName())
+			}
+		}
+	}
+	return nil
+}
+
+// claimVolume returns the claim, its bound lvm persistent volume and the node the volume is located on
+func claimVolume(clientset *kubernetes.Clientset, namespace string, pvcName string) (*v1.PersistentVolumeClaim, *v1.PersistentVolume, string, error) {
+	pvc, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), pvcName, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if pvc.Spec.VolumeName == "" {
+		return nil, nil, "", fmt.Errorf("pvc %s is not bound", pvcName)
+	}
+	pv, err := clientset.CoreV1().PersistentVolumes().Get(context.TODO(), pvc.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("volume %s not found: %s", pvc.Spec.VolumeName, err)
+	}
+	if !isLVMVolume(pv) {
+		return nil, nil, "", fmt.Errorf("volume %s of pvc %s is no lvm volume", pv.Name, pvcName)
+	}
+	node := helper.VolumeNode(pv)
+	if node == "" {
+		return nil, nil, "", fmt.Errorf("volume %s has no node affinity", pv.Name)
+	}
+	return pvc, pv, node, nil
+}
+
+// isLVMVolume returns true for persistent volumes of csi-driver-lvm or csi-lvm
+func isLVMVolume(pv *v1.PersistentVolume) bool {
+	if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == viper.GetString("provisioner") {
+		return true
+	}
+	p := pv.Annotations[provisionedByAnnotation]
+	return p == viper.GetString("provisioner") || p == legacyProvisioner
+}
+
+// volumeLVName returns the name of the logical volume backing a persistent volume
+func volumeLVName(pv *v1.PersistentVolume) string {
+	if pv.Spec.CSI != nil && pv.Spec.CSI.VolumeHandle != "" {
+		return pv.Spec.CSI.VolumeHandle
+	}
+	return pv.Name
+}
+
+// lvmVolumes returns all persistent volumes of csi-driver-lvm and csi-lvm
+func lvmVolumes(clientset *kubernetes.Clientset) ([]v1.PersistentVolume, error) {
+	pvs, err := clientset.CoreV1().PersistentVolumes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list persistent volumes: %v", err)
+	}
+	var result []v1.PersistentVolume
+	for _, pv := range pvs.Items {
+		if isLVMVolume(&pv) {
+			result = append(result, pv)
+		}
+	}
+	return result, nil
+}
+
+// lvmNodes returns the nodes given with --node or all nodes running a
+// csi-driver-lvm plugin pod or holding lvm persistent volumes
+func lvmNodes(clientset *kubernetes.Clientset) ([]string, error) {
+	if nodes := viper.GetStringSlice("node"); len(nodes) > 0 {
+		return nodes, nil
+	}
+
+	nodes := map[string]bool{}
+	pluginNodes, err := helper.FindPluginNodes(clientset, viper.GetString("provisioner"))
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range pluginNodes {
+		nodes[n] = true
+	}
+	pvs, err := lvmVolumes(clientset)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pvs {
+		if n := helper.VolumeNode(&pvs[i]); n != "" {
+			nodes[n] = true
+		}
+	}
+
+	var result []string
+	for n := range nodes {
+		result = append(result, n)
+	}
+	sort.Strings(result)
+	return result, nil
+}
@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+	"github.com/metal-stack/csilvmctl/cmd/internal/helper"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// clonedFromAnnotation is set on cloned claims to namespace/name of the source claim
+const clonedFromAnnotation = "csilvmctl.metal-stack.io/cloned-from"
+
+var (
+	cloneCmd = &cobra.Command{
+		Use:   "clone [namespace/]<pvc> <new-name>",
+		Short: "clone a PersistentVolumeClaim into a new claim on the same node",
+		Long: "create a new csi-driver-lvm PersistentVolumeClaim of the same size and storage class on the node of the claim " +
+			"and copy the data of a snapshot of its logical volume to it, with rsync for filesystems or tar if rsync is not available " +
+			"and with dd for block volumes. The source claim stays untouched and may be in use. For clone --namespace is the namespace " +
+			"of the new claim, the source claim is looked up in the namespace of the current context unless given as namespace/pvc.",
+		PreRun: bindPFlags,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cloneVolume(args)
+		},
+	}
+)
+
+func init() {
+	cloneCmd.Flags().String("snapshot-size", "", "copy-on-write space of the snapshot the data is copied from, default is 20% of the volume")
+}
+
+func cloneVolume(args []string) (err error) {
+	if len(args) < 2 {
+		return fmt.Errorf("no pvc and new name given")
+	}
+	pvcName, newName := args[0], args[1]
+	clientset, config, _, err := newClientset()
+	if err != nil {
+		return err
+	}
+	// --namespace selects the namespace of the new claim
+	namespace := contextNamespace()
+	if parts := strings.SplitN(pvcName, "/", 2); len(parts) == 2 {
+		namespace, pvcName = parts[0], parts[1]
+	}
+	targetNamespace := viper.GetString("namespace")
+	if targetNamespace == "" {
+		targetNamespace = namespace
+	}
+	pvc, pv, node, err := claimVolume(clientset, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+	_, err = clientset.CoreV1().PersistentVolumeClaims(targetNamespace).Get(context.TODO(), newName, metav1.GetOptions{})
+	if err == nil {
+		return fmt.Errorf("pvc %s already exists in namespace %s", newName, targetNamespace)
+	}
+
+	e, err := startExecutor(clientset, config, node, namespace, "clone")
+	if err != nil {
+		return err
+	}
+	defer e.Destroy()
+
+	vgname := volumeVG(pv, node)
+	lv, err := lvm.GetLV(e, vgname, volumeLVName(pv))
+	if err != nil {
+		return err
+	}
+	if lv == nil {
+		return fmt.Errorf("logical volume %s not found in volume group %s on node %s", volumeLVName(pv), vgname, node)
+	}
+	storageClass := ""
+	if pvc.Spec.StorageClassName != nil {
+		storageClass = *pvc.Spec.StorageClassName
+	}
+	if lv.HasTag(lvm.LegacyTag) {
+		// clones are always csi-driver-lvm claims
+		storageClasses, err := driverStorageClasses(clientset)
+		if err != nil {
+			return err
+		}
+		storageClass = storageClasses[lv.Type()]
+	}
+	if storageClass == "" {
+		return fmt.Errorf("no csi-driver-lvm storage class found for pvc %s", pvcName)
+	}
+	block := pvc.Spec.VolumeMode != nil && *pvc.Spec.VolumeMode == v1.PersistentVolumeBlock
+	fstype := ""
+	if !block {
+		fstype, err = filesystemType(e, lv.DevicePath())
+		if err != nil {
+			return err
+		}
+		if fstype == "" {
+			return fmt.Errorf("no filesystem found on %s", lv.DevicePath())
+		}
+	}
+
+	// the logical volume may be larger than the request, e.g. after resize
+	size := volumeRequest(pvc.Spec.Resources.Requests[v1.ResourceStorage], lv.Size)
+	fmt.Printf("Cloning volume %s (%s, %s) on node %s to %s/%s with storage class %s\n", pvcName, lv.Path(), formatBytes(lv.Size), node, targetNamespace, newName, storageClass)
+	if !viper.GetBool("yes") {
+		if err := helper.Prompt("Do you want to proceed? (y/n) ", "y"); err != nil {
+			return err
+		}
+	}
+	fmt.Println("Please wait ...")
+
+	snap, err := createTemporarySnapshot(e, lv, lv.Name+"-clone", viper.GetString("snapshot-size"))
+	if err != nil {
+		return err
+	}
+	defer removeSnapshot(e, snap)
+
+	_, err = clientset.CoreV1().PersistentVolumeClaims(targetNamespace).Create(context.TODO(), &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   newName,
+			Labels: pvc.Labels,
+			Annotations: map[string]string{
+				clonedFromAnnotation: namespace + "/" + pvcName,
+			},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			StorageClassName: &storageClass,
+			VolumeMode:       pvc.Spec.VolumeMode,
+			AccessModes:      pvc.Spec.AccessModes,
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceStorage: size,
+				},
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("could not create pvc %s: %v", newName, err)
+	}
+	// do not leave an incomplete clone behind
+	defer func() {
+		if err != nil {
+			deleteCreatedClaim(clientset, targetNamespace, newName)
+		}
+	}()
+
+	err = provisionClaim(clientset, node, targetNamespace, newName)
+	if err != nil {
+		return err
+	}
+	_, newPV, _, err := claimVolume(clientset, targetNamespace, newName)
+	if err != nil {
+		return err
+	}
+	// clones of csi-lvm volumes may live in another volume group
+	target, err := lvm.GetLV(e, volumeVG(newPV, node), volumeLVName(newPV))
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return fmt.Errorf("logical volume %s of pvc %s not found in volume group %s on node %s", volumeLVName(newPV), newName, volumeVG(newPV, node), node)
+	}
+
+	// the size of the snapshot is its copy-on-write space, not the size of the data
+	if target.Size < lv.Size {
+		return fmt.Errorf("volume %s with %s is smaller than %s with %s", target.Path(), formatBytes(target.Size), lv.Path(), formatBytes(lv.Size))
+	}
+	if block {
+		err = copyBlockDevice(e, snap, target)
+	} else {
+		err = copyFiles(e, snap, target, fstype)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Volume %s successfully cloned to %s/%s.\n", pvcName, targetNamespace, newName)
+	return nil
+}
+
+// createTemporarySnapshot creates a snapshot of the volume tagged for removal by cleanup
+func createTemporarySnapshot(e executor.Executor, lv *lvm.LogicalVolume, name string, size string) (*lvm.LogicalVolume, error) {
+	sizeOption, err := snapshotSizeOption(lv, size)
+	if err != nil {
+		return nil, err
+	}
+	stdout, stderr, err := e.Exec("lvcreate -y -s -n "+name+" "+sizeOption+" --addtag "+lvm.TemporaryTag+" "+lv.Path(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create snapshot %s of %s: %v %s %s", name, lv.Path(), err, stdout, stderr)
+	}
+	snap, err := lvm.GetLV(e, lv.VG, name)
+	if err != nil {
+		return nil, err
+	}
+	if snap == nil {
+		return nil, fmt.Errorf("created snapshot %s not found", name)
+	}
+	return snap, nil
+}
+
+// copyBlockDevice copies the content of the source volume to the target volume, which must not be smaller
+func copyBlockDevice(e executor.Executor, source *lvm.LogicalVolume, target *lvm.LogicalVolume) error {
+	stdout, stderr, err := e.Exec("dd if="+source.DevicePath()+" of="+target.DevicePath()+" bs=4M conv=fsync status=none", nil)
+	if err != nil {
+		return fmt.Errorf("unable to copy %s to %s: %v %s %s", source.Path(), target.Path(), err, stdout, stderr)
+	}
+	return nil
+}
+
+// copyFiles copies the files of the source volume to the filesystem of the target
+// volume with rsync, or with tar if rsync is not installed in the executor
+func copyFiles(e executor.Executor, source *lvm.LogicalVolume, target *lvm.LogicalVolume, fstype string) error {
+	// snapshots of mounted filesystems need their journal replayed, so they are
+	// mounted writable, xfs refuses to mount the duplicate uuid of the snapshot
+	options := ""
+	if fstype == fsXFS {
+		options = "nouuid"
+	}
+	return withMountedFilesystem(e, source.DevicePath(), options, func(src string) error {
+		return withMountedFilesystem(e, target.DevicePath(), "", func(dst string) error {
+			stdout, stderr, err := e.Exec("if command -v rsync >/dev/null; then rsync -aHAX --numeric-ids "+src+"/ "+dst+"/; "+
+				"else tar -C "+src+" -cf - . | tar -C "+dst+" -xpf -; fi", nil)
+			if err != nil {
+				return fmt.Errorf("unable to copy data from %s to %s: %v %s %s", source.Path(), target.Path(), err, stdout, stderr)
+			}
+			return nil
+		})
+	})
+}
@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+	"github.com/metal-stack/csilvmctl/cmd/internal/helper"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	convertCmd = &cobra.Command{
+		Use:   "convert <pvc>",
+		Short: "convert the volume type of a csi-driver-lvm PersistentVolumeClaim",
+		Long: "convert the logical volume of a csi-driver-lvm PersistentVolumeClaim between linear and mirror with lvconvert " +
+			"and rebind the claim to the storage class of the new type. Conversions to and from striped copy the data to a new " +
+			"volume striped across all physical volumes of the volume group. The claim must not be in use.",
+		PreRun: bindPFlags,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return convertVolume(args)
+		},
+	}
+)
+
+func init() {
+	convertCmd.Flags().String("to", "", "target volume type, linear, mirror or striped")
+	convertCmd.Flags().Duration("interval", 10*time.Second, "interval for reporting the sync progress")
+	convertCmd.Flags().Duration("timeout", 24*time.Hour, "how long to wait for a mirror to get in sync, 0 waits forever")
+}
+
+func convertVolume(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("no pvc given")
+	}
+	pvcName := args[0]
+	to := viper.GetString("to")
+	if to != "linear" && to != "mirror" && to != "striped" {
+		return fmt.Errorf("unknown volume type %q, must be linear, mirror or striped", to)
+	}
+
+	clientset, config, namespace, err := newClientset()
+	if err != nil {
+		return err
+	}
+	pvc, pv, node, err := claimVolume(clientset, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+	storageClasses, err := driverStorageClasses(clientset)
+	if err != nil {
+		return err
+	}
+	newStorageClass := storageClasses[to]
+	if newStorageClass == "" {
+		return fmt.Errorf("no matching csi-driver-lvm storage class found for type %s", to)
+	}
+	err = checkClaimUnused(clientset, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+
+	e, err := startExecutor(clientset, config, node, namespace, "convert")
+	if err != nil {
+		return err
+	}
+	defer e.Destroy()
+
+	vgname := volumeVG(pv, node)
+	lv, err := lvm.GetLV(e, vgname, volumeLVName(pv))
+	if err != nil {
+		return err
+	}
+	if lv == nil {
+		return fmt.Errorf("logical volume %s not found in volume group %s on node %s", volumeLVName(pv), vgname, node)
+	}
+	if !lv.HasTag(lvm.DriverTag) {
+		return fmt.Errorf("volume %s is no csi-driver-lvm volume, migrate it first", pv.Name)
+	}
+	command, stripes, err := conversion(e, lv, to)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Converting volume %s (%s) on node %s from %s to %s with storage class %s\n", pvcName, lv.Path(), node, lv.Type(), to, newStorageClass)
+	if command == "" {
+		fmt.Printf("The data is copied to a new volume, %s needs %s free\n", vgname, formatBytes(lv.Size))
+	}
+	if !viper.GetBool("yes") {
+		if err := helper.Prompt("Do you want to proceed? (y/n) ", "y"); err != nil {
+			return err
+		}
+	}
+	fmt.Println("Please wait ...")
+
+	// the volume the claim is rebound to, the old one is removed if the data was copied
+	copied := command == ""
+	target := lv
+	if copied {
+		target, err = createTypedVolume(e, vgname, lv.Name+"-convert", lv.Size, to, stripes, "")
+		if err != nil {
+			return err
+		}
+		err = copyBlockDevice(e, lv, target)
+		if err != nil {
+			return err
+		}
+	} else {
+		stdout, stderr, err := e.Exec(command, nil)
+		if err != nil {
+			return fmt.Errorf("unable to convert %s: %v %s %s", lv.Path(), err, stdout, stderr)
+		}
+	}
+	if to == "mirror" {
+		target, err = waitForSync(e, raidVolume{node: node, lv: *target, namespace: namespace, claim: pvcName})
+		if err != nil {
+			return err
+		}
+	}
+
+	s, _ := pvc.Spec.Resources.Requests.Storage().AsInt64()
+	swap := &volumeSwap{
+		clientset:    clientset,
+		executor:     e,
+		namespace:    namespace,
+		node:         node,
+		vg:           vgname,
+		storageClass: newStorageClass,
+		size:         resource.NewQuantity(s, resource.BinarySI).String(),
+	}
+	if copied {
+		swap.afterRename = func(lvName string) error {
+			stdout, stderr, err := e.Exec("lvchange --deltag "+lvm.TemporaryTag+" "+vgname+"/"+lvName, nil)
+			if err != nil {
+				return fmt.Errorf("unable to remove tag %s from %s: %s %s %s", lvm.TemporaryTag, lvName, err, stdout, stderr)
+			}
+			return nil
+		}
+	}
+	_, err = swap.run(pvc, target.Name)
+	if err != nil {
+		return err
+	}
+	if copied {
+		stdout, stderr, err := e.Exec("lvremove -y "+lv.Path(), nil)
+		if err != nil {
+			return fmt.Errorf("unable to remove old volume %s: %s %s %s", lv.Path(), err, stdout, stderr)
+		}
+	}
+
+	fmt.Printf("Volume %s successfully converted to %s. You can start your pod again.\n", pvcName, to)
+	fmt.Printf("Make sure to also change the storage class in your source files to the new storageClassName %s.\n", newStorageClass)
+	return nil
+}
+
+// conversion returns the lvconvert command converting the volume to the
+// given type after checking the volume group has enough space for it. lvm can
+// only change the number of stripes by reshaping a raid volume, which changes
+// its size, so conversions to and from striped return no command and the
+// number of stripes of a new volume the data is copied to.
+func conversion(e executor.Executor, lv *lvm.LogicalVolume, to string) (string, int, error) {
+	from := lv.Type()
+	switch {
+	case from == to:
+		return "", 0, fmt.Errorf("volume %s is already of type %s", lv.Path(), to)
+	case from == "striped" || to == "striped":
+		if from != "linear" && from != "mirror" && from != "striped" {
+			break
+		}
+		stripes, err := checkCopySpace(e, lv, to)
+		return "", stripes, err
+	case from == "linear" && to == "mirror":
+		err := checkMirrorSpace(e, lv)
+		if err != nil {
+			return "", 0, err
+		}
+		return "lvconvert -y --type raid1 -m 1 " + lv.Path(), 0, nil
+	case from == "mirror" && to == "linear":
+		return "lvconvert -y -m 0 " + lv.Path(), 0, nil
+	}
+	return "", 0, fmt.Errorf("conversion from %s to %s is not supported", from, to)
+}
+
+// checkCopySpace makes sure a new volume of the given type and the size of the
+// volume fits into the volume group besides it. Like csi-driver-lvm, striped
+// volumes are striped across all physical volumes, each of them needs its share
+// of the size. It returns the number of stripes.
+func checkCopySpace(e executor.Executor, lv *lvm.LogicalVolume, to string) (int, error) {
+	vg, err := lvm.GetVG(e, lv.VG)
+	if err != nil {
+		return 0, err
+	}
+	if vg == nil {
+		return 0, fmt.Errorf("volume group %s not found", lv.VG)
+	}
+	pvs, err := lvm.ListVGPVs(e, lv.VG)
+	if err != nil {
+		return 0, err
+	}
+	switch to {
+	case "striped":
+		if len(pvs) < 2 {
+			return 0, fmt.Errorf("volume group %s has only %d physical volume, striping needs at least 2", lv.VG, len(pvs))
+		}
+		share := lv.Size/uint64(len(pvs)) + vg.ExtentSize
+		for _, pv := range pvs {
+			if pv.Free < share {
+				return 0, fmt.Errorf("physical volume %s has %s free, %s needed for its stripe of %s", pv.Name, formatBytes(pv.Free), formatBytes(share), lv.Path())
+			}
+		}
+		return len(pvs), nil
+	case "mirror":
+		// the legs need distinct physical volumes
+		legs := 0
+		for _, pv := range pvs {
+			if pv.Free >= lv.Size+vg.ExtentSize {
+				legs++
+			}
+		}
+		if legs < 2 {
+			return 0, fmt.Errorf("no two physical volumes of %s have %s free for the legs of the new mirror", lv.VG, formatBytes(lv.Size+vg.ExtentSize))
+		}
+	default:
+		if vg.Free < lv.Size+vg.ExtentSize {
+			return 0, fmt.Errorf("volume group %s has %s free, %s needed for the new volume", lv.VG, formatBytes(vg.Free), formatBytes(lv.Size+vg.ExtentSize))
+		}
+	}
+	return 0, nil
+}
+
+// checkMirrorSpace makes sure a second leg and the raid metadata of the linear
+// volume fit on physical volumes of the volume group
+func checkMirrorSpace(e executor.Executor, lv *lvm.LogicalVolume) error {
+	vg, err := lvm.GetVG(e, lv.VG)
+	if err != nil {
+		return err
+	}
+	if vg == nil {
+		return fmt.Errorf("volume group %s not found", lv.VG)
+	}
+	pvs, err := lvm.ListVGPVs(e, lv.VG)
+	if err != nil {
+		return err
+	}
+
+	// each leg needs an additional extent for its metadata
+	legFits, metaFits := false, false
+	for _, pv := range pvs {
+		if contains(lv.Devices, pv.Name) {
+			metaFits = metaFits || pv.Free >= vg.ExtentSize
+			continue
+		}
+		legFits = legFits || pv.Free >= lv.Size+vg.ExtentSize
+	}
+	if !legFits {
+		return fmt.Errorf("no physical volume besides %v has %s free for the second leg of %s", lv.Devices, formatBytes(lv.Size+vg.ExtentSize), lv.Path())
+	}
+	if !metaFits {
+		return fmt.Errorf("no free extent for the raid metadata on %v", lv.Devices)
+	}
+	return nil
+}
@@ -0,0 +1,854 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+	"github.com/metal-stack/csilvmctl/cmd/internal/helper"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	nodeCmd = &cobra.Command{
+		Use:   "node",
+		Short: "manage the local volumes of a node",
+	}
+	nodeDrainCmd = &cobra.Command{
+		Use:   "drain <node>",
+		Short: "move all csi-driver-lvm and csi-lvm PersistentVolumeClaims of a node to other nodes",
+		Long: "plan a target node for every claim of the node by the free space of the volume groups, scale the workloads using the claims down, " +
+			"copy each volume to a new csi-driver-lvm volume on its target node, rebind the claim to it and scale the workloads up again. " +
+			"The progress is kept in a plan file, an interrupted drain is continued by running the command again.",
+		PreRun: bindPFlags,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return drainNode(args)
+		},
+	}
+)
+
+func init() {
+	nodeDrainCmd.Flags().String("plan", "", "file to keep the plan and progress in, default is drain-<node>.json")
+	nodeDrainCmd.Flags().StringSlice("target-node", nil, "nodes to move the volumes to, default is all other schedulable nodes with lvm volumes or a csi-driver-lvm plugin")
+	nodeDrainCmd.Flags().Bool("dry-run", false, "only show the plan")
+	nodeDrainCmd.Flags().Bool("keep-source", false, "keep the logical volumes on the drained node instead of removing them")
+	nodeDrainCmd.Flags().Duration("timeout", 5*time.Minute, "how long to wait for the pods of scaled down workloads to terminate")
+	nodeDrainCmd.Flags().Duration("interval", 10*time.Second, "interval for reporting the copy progress")
+	nodeCmd.AddCommand(nodeDrainCmd)
+}
+
+// phases of a volume during a drain
+const (
+	drainPending = "pending"
+	// drainProvisioned means the temporary claim is bound to a new volume on the target node
+	drainProvisioned = "provisioned"
+	drainCopied      = "copied"
+	// drainRebound means the claim is bound to the new volume
+	drainRebound = "rebound"
+	// drainDone means the old volume is removed
+	drainDone = "done"
+)
+
+// drainPlan is the plan of a node drain, it is saved after every step
+type drainPlan struct {
+	Node    string         `json:"node"`
+	Volumes []*drainVolume `json:"volumes"`
+	// Workloads are the scaled down workloads with their original replicas
+	Workloads []drainWorkload `json:"workloads"`
+}
+
+// drainVolume is a claim to move to another node
+type drainVolume struct {
+	Namespace string            `json:"namespace"`
+	Claim     string            `json:"claim"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	// Volume is the persistent volume on the drained node
+	Volume string `json:"volume"`
+	VG     string `json:"vg"`
+	LV     string `json:"lv"`
+	Type   string `json:"type"`
+	// Size of the logical volume in bytes
+	Size         uint64            `json:"size"`
+	Request      resource.Quantity `json:"request"`
+	StorageClass string            `json:"storageClass"`
+	Block        bool              `json:"block"`
+	Target       string            `json:"target"`
+	// TempClaim is the claim the new volume is provisioned with, it is labeled as managed by csilvmctl
+	TempClaim string `json:"tempClaim"`
+	// NewVolume is the persistent volume on the target node
+	NewVolume string `json:"newVolume,omitempty"`
+	// ReclaimPolicy of the new volume, it is retained until the claim is rebound
+	ReclaimPolicy string `json:"reclaimPolicy,omitempty"`
+	Phase         string `json:"phase"`
+}
+
+func (d *drainVolume) String() string {
+	return d.Namespace + "/" + d.Claim
+}
+
+// ownTempClaim returns true if the temporary claim exists, it fails if a claim
+// with its name was not created by csilvmctl
+func (d *drainVolume) ownTempClaim(clientset *kubernetes.Clientset) (bool, error) {
+	pvc, err := clientset.CoreV1().PersistentVolumeClaims(d.Namespace).Get(context.TODO(), d.TempClaim, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if pvc.Labels[helper.ManagedByLabel] != helper.ManagedByValue {
+		return false, fmt.Errorf("pvc %s/%s was not created by csilvmctl, refusing to use it as temporary claim", d.Namespace, d.TempClaim)
+	}
+	return true, nil
+}
+
+// drainWorkload is a workload scaled down for the drain
+type drainWorkload struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Replicas  int32  `json:"replicas"`
+}
+
+func (w drainWorkload) workload() workload {
+	return workload{kind: w.Kind, namespace: w.Namespace, name: w.Name}
+}
+
+func drainNode(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("no node given")
+	}
+	node := args[0]
+	planFile := viper.GetString("plan")
+	if planFile == "" {
+		planFile = "drain-" + node + ".json"
+	}
+
+	clientset, config, namespace, err := newClientset()
+	if err != nil {
+		return err
+	}
+
+	plan, err := loadDrainPlan(planFile)
+	if err != nil {
+		return err
+	}
+	resume := plan != nil
+	if resume && plan.Node != node {
+		return fmt.Errorf("plan file %s is for node %s, not %s", planFile, plan.Node, node)
+	}
+
+	targets := viper.GetStringSlice("target-node")
+	if resume {
+		targets = nil
+		for _, v := range plan.Volumes {
+			if !contains(targets, v.Target) {
+				targets = append(targets, v.Target)
+			}
+		}
+	} else if len(targets) == 0 {
+		targets, err = drainTargets(clientset, node)
+		if err != nil {
+			return err
+		}
+	}
+	if contains(targets, node) {
+		return fmt.Errorf("node %s cannot be a target of its own drain", node)
+	}
+	executors, err := startExecutors(clientset, config, append([]string{node}, targets...), namespace, "drain")
+	if err != nil {
+		return err
+	}
+	defer executors.Destroy()
+
+	if resume {
+		fmt.Printf("Continuing the drain of node %s from %s\n", node, planFile)
+	} else {
+		plan, err = planDrain(clientset, executors, node, targets)
+		if err != nil {
+			return err
+		}
+		if len(plan.Volumes) == 0 {
+			fmt.Printf("No lvm volumes to move on node %s\n", node)
+			return nil
+		}
+	}
+	printDrainPlan(plan)
+	if viper.GetBool("dry-run") {
+		return nil
+	}
+	if !viper.GetBool("yes") {
+		if err := helper.Prompt("Do you want to proceed? (y/n) ", "y"); err != nil {
+			return err
+		}
+	}
+	err = saveDrainPlan(planFile, plan)
+	if err != nil {
+		return err
+	}
+
+	err = runDrain(clientset, config, namespace, executors, plan, planFile)
+	if err != nil {
+		return fmt.Errorf("%v, run the command again to continue the drain with %s, scaled down workloads are kept at 0 replicas", err, planFile)
+	}
+
+	for _, w := range plan.Workloads {
+		fmt.Printf("Scaling %s back to %d replicas\n", w.workload(), w.Replicas)
+		err = scaleWorkload(clientset, w.workload(), w.Replicas)
+		if err != nil {
+			return fmt.Errorf("%v, scale the remaining workloads of %s manually", err, planFile)
+		}
+	}
+	err = os.Remove(planFile)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Node %s drained, %d volumes moved.\n", node, len(plan.Volumes))
+	return nil
+}
+
+// drainTargets returns the schedulable nodes with lvm volumes or a csi-driver-lvm plugin except the drained node
+func drainTargets(clientset *kubernetes.Clientset, drained string) ([]string, error) {
+	nodes, err := lvmNodes(clientset)
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	for _, name := range nodes {
+		if name == drained {
+			continue
+		}
+		n, err := clientset.CoreV1().Nodes().Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to get node %s: %v", name, err)
+		}
+		if !n.Spec.Unschedulable {
+			targets = append(targets, name)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no target nodes found to drain node %s to", drained)
+	}
+	return targets, nil
+}
+
+// planDrain collects the claims of the node and assigns them, largest first,
+// to the target node with the most free space left in its volume group
+func planDrain(clientset *kubernetes.Clientset, executors executorPool, node string, targets []string) (*drainPlan, error) {
+	e := executors[node]
+	pvs, err := lvmVolumes(clientset)
+	if err != nil {
+		return nil, err
+	}
+	storageClasses, err := driverStorageClasses(clientset)
+	if err != nil {
+		return nil, err
+	}
+	lvs, err := listNodeLVs(e, node)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &drainPlan{Node: node}
+	for i := range pvs {
+		pv := &pvs[i]
+		if helper.VolumeNode(pv) != node {
+			continue
+		}
+		if pv.Status.Phase != v1.VolumeBound || pv.Spec.ClaimRef == nil {
+			fmt.Printf("warning: volume %s is %s, it is not moved\n", pv.Name, pv.Status.Phase)
+			continue
+		}
+		pvc, err := clientset.CoreV1().PersistentVolumeClaims(pv.Spec.ClaimRef.Namespace).Get(context.TODO(), pv.Spec.ClaimRef.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		vgname := volumeVG(pv, node)
+		lv, err := lvm.GetLV(e, vgname, volumeLVName(pv))
+		if err != nil {
+			return nil, err
+		}
+		if lv == nil {
+			return nil, fmt.Errorf("logical volume %s of pvc %s/%s not found in volume group %s on node %s", volumeLVName(pv), pvc.Namespace, pvc.Name, vgname, node)
+		}
+		storageClass := ""
+		if pvc.Spec.StorageClassName != nil {
+			storageClass = *pvc.Spec.StorageClassName
+		}
+		if lv.HasTag(lvm.LegacyTag) {
+			// csi-lvm volumes become csi-driver-lvm volumes on their new node
+			storageClass = storageClasses[lv.Type()]
+		}
+		if storageClass == "" {
+			return nil, fmt.Errorf("no csi-driver-lvm storage class found for pvc %s/%s of type %s", pvc.Namespace, pvc.Name, lv.Type())
+		}
+		for _, snap := range lvs {
+			if snap.VG == lv.VG && snap.Origin == lv.Name {
+				fmt.Printf("warning: snapshot %s of pvc %s/%s is not moved\n", snap.Path(), pvc.Namespace, pvc.Name)
+			}
+		}
+		plan.Volumes = append(plan.Volumes, &drainVolume{
+			Namespace:    pvc.Namespace,
+			Claim:        pvc.Name,
+			Labels:       pvc.Labels,
+			Volume:       pv.Name,
+			VG:           lv.VG,
+			LV:           lv.Name,
+			Type:         lv.Type(),
+			Size:         lv.Size,
+			Request:      volumeRequest(pvc.Spec.Resources.Requests[v1.ResourceStorage], lv.Size),
+			StorageClass: storageClass,
+			Block:        pvc.Spec.VolumeMode != nil && *pvc.Spec.VolumeMode == v1.PersistentVolumeBlock,
+			TempClaim:    pvc.Name + "-drain-" + utilrand.String(5),
+			Phase:        drainPending,
+		})
+	}
+
+	free := map[string]uint64{}
+	extentSize := map[string]uint64{}
+	for _, t := range targets {
+		vg, err := lvm.GetVG(executors[t], driverVG(t))
+		if err != nil {
+			return nil, err
+		}
+		if vg == nil {
+			return nil, fmt.Errorf("volume group %s not found on node %s", driverVG(t), t)
+		}
+		free[t] = vg.Free
+		extentSize[t] = vg.ExtentSize
+	}
+	sort.SliceStable(plan.Volumes, func(i, j int) bool { return plan.Volumes[i].Size > plan.Volumes[j].Size })
+	for _, v := range plan.Volumes {
+		legs := uint64(1)
+		if v.Type == "mirror" {
+			legs = 2
+		}
+		for _, t := range targets {
+			needed := (v.Size + extentSize[t]) * legs
+			if free[t] >= needed && (v.Target == "" || free[t] > free[v.Target]) {
+				v.Target = t
+			}
+		}
+		if v.Target == "" {
+			return nil, fmt.Errorf("no target node has enough free space for pvc %s with %s", v, formatBytes(v.Size))
+		}
+		free[v.Target] -= (v.Size + extentSize[v.Target]) * legs
+	}
+	return plan, nil
+}
+
+func printDrainPlan(plan *drainPlan) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "CLAIM\tLV\tTYPE\tSIZE\tSTORAGE CLASS\tTARGET\tPHASE")
+	for _, v := range plan.Volumes {
+		fmt.Fprintf(w, "%s\t%s/%s\t%s\t%s\t%s\t%s\t%s\n", v, v.VG, v.LV, v.Type, formatBytes(v.Size), v.StorageClass, v.Target, v.Phase)
+	}
+	w.Flush()
+}
+
+// runDrain scales the workloads of the claims down and moves the volumes phase by phase
+func runDrain(clientset *kubernetes.Clientset, config *restclient.Config, namespace string, executors executorPool, plan *drainPlan, planFile string) error {
+	err := checkDrainSizes(clientset, executors, plan)
+	if err != nil {
+		return err
+	}
+	err = scaleDownWorkloads(clientset, plan, planFile)
+	if err != nil {
+		return err
+	}
+	for _, v := range plan.Volumes {
+		for v.Phase != drainDone {
+			switch v.Phase {
+			case drainPending:
+				fmt.Printf("%s: provisioning a new volume on node %s\n", v, v.Target)
+				err = provisionDrainVolume(clientset, v)
+			case drainProvisioned:
+				fmt.Printf("%s: copying %s from node %s to node %s\n", v, formatBytes(v.Size), plan.Node, v.Target)
+				err = copyDrainVolume(clientset, config, namespace, plan.Node, executors, v)
+			case drainCopied:
+				fmt.Printf("%s: rebinding the claim to volume %s\n", v, v.NewVolume)
+				err = rebindDrainVolume(clientset, v)
+			case drainRebound:
+				err = removeDrainSource(clientset, executors[plan.Node], v)
+			default:
+				err = fmt.Errorf("unknown phase %q", v.Phase)
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %v", v, err)
+			}
+			err = saveDrainPlan(planFile, plan)
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Printf("%s: moved to node %s\n", v, v.Target)
+	}
+	return nil
+}
+
+// checkDrainSizes makes sure the new volumes can hold the old ones before
+// anything is scaled down. Requests of pending volumes are raised to the size
+// of their logical volume, provisioned volumes are checked on their node.
+func checkDrainSizes(clientset *kubernetes.Clientset, executors executorPool, plan *drainPlan) error {
+	for _, v := range plan.Volumes {
+		switch v.Phase {
+		case drainPending:
+			v.Request = volumeRequest(v.Request, v.Size)
+		case drainProvisioned:
+			pv, err := clientset.CoreV1().PersistentVolumes().Get(context.TODO(), v.NewVolume, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("new volume %s of pvc %s not found: %v", v.NewVolume, v, err)
+			}
+			target, err := lvm.GetLV(executors[v.Target], driverVG(v.Target), volumeLVName(pv))
+			if err != nil {
+				return err
+			}
+			if target != nil && target.Size < v.Size {
+				return fmt.Errorf("volume %s of pvc %s with %s is smaller than %s", target.Path(), v, formatBytes(target.Size), formatBytes(v.Size))
+			}
+		}
+	}
+	return nil
+}
+
+// scaleDownWorkloads scales all workloads using the claims to zero and waits
+// until their pods are gone. The original replicas are saved in the plan
+// before a workload is scaled down.
+func scaleDownWorkloads(clientset *kubernetes.Clientset, plan *drainPlan, planFile string) error {
+	var workloads []workload
+	for _, v := range plan.Volumes {
+		if v.Phase == drainRebound || v.Phase == drainDone {
+			continue
+		}
+		ws, err := claimWorkloads(clientset, v.Namespace, v.Claim)
+		if err != nil {
+			return err
+		}
+		for _, w := range ws {
+			if get, _ := scaleClient(clientset, w); get == nil {
+				return fmt.Errorf("pvc %s is used by %s which cannot be scaled, stop it first", v, w)
+			}
+			workloads = append(workloads, w)
+		}
+	}
+	for _, w := range workloads {
+		known := false
+		for _, d := range plan.Workloads {
+			known = known || d.workload() == w
+		}
+		if !known {
+			replicas, err := workloadReplicas(clientset, w)
+			if err != nil {
+				return err
+			}
+			plan.Workloads = append(plan.Workloads, drainWorkload{Kind: w.kind, Namespace: w.namespace, Name: w.name, Replicas: replicas})
+			err = saveDrainPlan(planFile, plan)
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Scaling %s down\n", w)
+		err := scaleWorkload(clientset, w, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	timeout := time.Now().Add(viper.GetDuration("timeout"))
+	for _, v := range plan.Volumes {
+		if v.Phase == drainRebound || v.Phase == drainDone {
+			continue
+		}
+		for {
+			err := checkClaimUnused(clientset, v.Namespace, v.Claim)
+			if err == nil {
+				break
+			}
+			if time.Now().After(timeout) {
+				return err
+			}
+			time.Sleep(2 * time.Second)
+		}
+	}
+	return nil
+}
+
+// provisionDrainVolume creates the temporary claim on the target node and retains its new volume
+func provisionDrainVolume(clientset *kubernetes.Clientset, v *drainVolume) error {
+	exists, err := v.ownTempClaim(clientset)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		var volumeMode *v1.PersistentVolumeMode
+		if v.Block {
+			block := v1.PersistentVolumeBlock
+			volumeMode = &block
+		}
+		_, err = clientset.CoreV1().PersistentVolumeClaims(v.Namespace).Create(context.TODO(), &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: v.TempClaim,
+				Labels: map[string]string{
+					helper.ManagedByLabel: helper.ManagedByValue,
+				},
+			},
+			Spec: v1.PersistentVolumeClaimSpec{
+				StorageClassName: &v.StorageClass,
+				VolumeMode:       volumeMode,
+				AccessModes: []v1.PersistentVolumeAccessMode{
+					v1.ReadWriteOnce,
+				},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceStorage: v.Request,
+					},
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("could not create pvc %s: %v", v.TempClaim, err)
+		}
+	}
+	err = provisionClaim(clientset, v.Target, v.Namespace, v.TempClaim)
+	if err != nil {
+		return err
+	}
+	_, pv, node, err := claimVolume(clientset, v.Namespace, v.TempClaim)
+	if err != nil {
+		return err
+	}
+	if node != v.Target {
+		return fmt.Errorf("pvc %s was provisioned on node %s instead of %s", v.TempClaim, node, v.Target)
+	}
+	v.NewVolume = pv.Name
+	v.ReclaimPolicy = string(pv.Spec.PersistentVolumeReclaimPolicy)
+	err = setVolumeToRetain(clientset, pv.Name)
+	if err != nil {
+		return err
+	}
+	v.Phase = drainProvisioned
+	return nil
+}
+
+// copyDrainVolume copies the old logical volume to the new one on the target node
+func copyDrainVolume(clientset *kubernetes.Clientset, config *restclient.Config, namespace string, node string, executors executorPool, v *drainVolume) error {
+	src, dst := executors[node], executors[v.Target]
+	source, err := lvm.GetLV(src, v.VG, v.LV)
+	if err != nil {
+		return err
+	}
+	if source == nil {
+		return fmt.Errorf("logical volume %s/%s not found", v.VG, v.LV)
+	}
+	if source.IsOpen() && source.HasTag(lvm.LegacyTag) {
+		// csi-lvm keeps its volumes mounted on the host after the pods are gone
+		err = unmountLegacyVolume(clientset, config, node, namespace, v.Volume)
+		if err != nil {
+			return err
+		}
+		source, err = lvm.GetLV(src, v.VG, v.LV)
+		if err != nil {
+			return err
+		}
+		if source == nil {
+			return fmt.Errorf("logical volume %s/%s not found", v.VG, v.LV)
+		}
+	}
+	if source.IsOpen() {
+		return fmt.Errorf("logical volume %s is still open, e.g. mounted", source.Path())
+	}
+	pv, err := clientset.CoreV1().PersistentVolumes().Get(context.TODO(), v.NewVolume, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("new volume %s not found: %v", v.NewVolume, err)
+	}
+	if pv.Spec.ClaimRef == nil || pv.Spec.ClaimRef.Namespace != v.Namespace || pv.Spec.ClaimRef.Name != v.TempClaim {
+		return fmt.Errorf("new volume %s is not bound to the temporary pvc %s", v.NewVolume, v.TempClaim)
+	}
+	target, err := lvm.GetLV(dst, driverVG(v.Target), volumeLVName(pv))
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return fmt.Errorf("logical volume %s not found in volume group %s on node %s", volumeLVName(pv), driverVG(v.Target), v.Target)
+	}
+	if target.Size < source.Size {
+		return fmt.Errorf("volume %s with %s is smaller than %s with %s", target.Path(), formatBytes(target.Size), source.Path(), formatBytes(source.Size))
+	}
+	err = copyBetweenNodes(src, source, dst, target, viper.GetDuration("interval"))
+	if err != nil {
+		return err
+	}
+	v.Phase = drainCopied
+	return nil
+}
+
+// unmountLegacyVolume unmounts the csi-lvm volume below /tmp/csi-lvm on the node
+func unmountLegacyVolume(clientset *kubernetes.Clientset, config *restclient.Config, node string, namespace string, volume string) error {
+	e, err := newExecutorMode(legacyExecutorMode(), clientset, config, node, namespace, helper.MigratorPodPrefix+volume)
+	if err != nil {
+		return err
+	}
+	err = e.Start()
+	if err != nil {
+		return fmt.Errorf("unable to start executor on node %s: %v", node, err)
+	}
+	defer e.Destroy()
+
+	dir := "/tmp/csi-lvm/" + volume
+	stdout, stderr, err := e.Exec("if mountpoint -q "+dir+"; then umount "+dir+"; fi", nil)
+	if err != nil {
+		return fmt.Errorf("unable to umount volume %s: %s %s %s", volume, err, stdout, stderr)
+	}
+	return nil
+}
+
+// copyBetweenNodes streams the content of the source volume through this host into the target volume on another node
+func copyBetweenNodes(src executor.Executor, source *lvm.LogicalVolume, dst executor.Executor, target *lvm.LogicalVolume, interval time.Duration) error {
+	r, w := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		stderr, err := dst.Stream("dd of="+target.DevicePath()+" bs=4M conv=fsync status=none", r, nil)
+		if err != nil {
+			err = fmt.Errorf("unable to write %s: %v %s", target.Path(), err, stderr)
+		}
+		// stops the reading side if writing failed
+		r.CloseWithError(err)
+		written <- err
+	}()
+	p := newProgressWriter(w, source.Size, interval)
+	stderr, err := src.Stream("dd if="+source.DevicePath()+" bs=4M status=none", nil, p)
+	if err != nil {
+		err = fmt.Errorf("unable to read %s: %v %s", source.Path(), err, stderr)
+	}
+	w.CloseWithError(err)
+	writeErr := <-written
+	if writeErr != nil {
+		return writeErr
+	}
+	if err != nil {
+		return err
+	}
+	p.report()
+	return nil
+}
+
+// rebindDrainVolume recreates the claim bound to the new volume
+func rebindDrainVolume(clientset *kubernetes.Clientset, v *drainVolume) error {
+	pvcs := clientset.CoreV1().PersistentVolumeClaims(v.Namespace)
+	vols := clientset.CoreV1().PersistentVolumes()
+
+	err := setVolumeToRetain(clientset, v.Volume)
+	if err != nil {
+		return err
+	}
+	pvc, err := pvcs.Get(context.TODO(), v.Claim, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return err
+	case pvc.Spec.VolumeName == v.Volume:
+		err = deleteClaimAndWait(clientset, v.Namespace, v.Claim)
+		if err != nil {
+			return err
+		}
+	}
+	exists, err := v.ownTempClaim(clientset)
+	if err != nil {
+		return err
+	}
+	if exists {
+		err = deleteClaimAndWait(clientset, v.Namespace, v.TempClaim)
+		if err != nil {
+			return err
+		}
+	}
+
+	// reserve the released new volume for the recreated claim
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pv, err := vols.Get(context.TODO(), v.NewVolume, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if pv.Spec.ClaimRef != nil && pv.Spec.ClaimRef.Namespace == v.Namespace && pv.Spec.ClaimRef.Name == v.Claim {
+			return nil
+		}
+		pv.Spec.ClaimRef = &v1.ObjectReference{
+			Kind:      "PersistentVolumeClaim",
+			Namespace: v.Namespace,
+			Name:      v.Claim,
+		}
+		_, err = vols.Update(context.TODO(), pv, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to reserve volume %s for pvc %s: %v", v.NewVolume, v, err)
+	}
+
+	_, err = pvcs.Get(context.TODO(), v.Claim, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		var volumeMode *v1.PersistentVolumeMode
+		if v.Block {
+			block := v1.PersistentVolumeBlock
+			volumeMode = &block
+		}
+		_, err = pvcs.Create(context.TODO(), &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   v.Claim,
+				Labels: v.Labels,
+			},
+			Spec: v1.PersistentVolumeClaimSpec{
+				StorageClassName: &v.StorageClass,
+				VolumeMode:       volumeMode,
+				VolumeName:       v.NewVolume,
+				AccessModes: []v1.PersistentVolumeAccessMode{
+					v1.ReadWriteOnce,
+				},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceStorage: v.Request,
+					},
+				},
+			},
+		}, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("could not create pvc %s: %v", v, err)
+	}
+	for i := 0; ; i++ {
+		pvc, err := pvcs.Get(context.TODO(), v.Claim, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if pvc.Status.Phase == v1.ClaimBound {
+			break
+		}
+		if i == 60 {
+			return fmt.Errorf("pvc %s is not bound to volume %s after 60s", v, v.NewVolume)
+		}
+		time.Sleep(1 * time.Second)
+	}
+
+	// restore the reclaim policy of the storage class
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pv, err := vols.Get(context.TODO(), v.NewVolume, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		pv.Spec.PersistentVolumeReclaimPolicy = v1.PersistentVolumeReclaimPolicy(v.ReclaimPolicy)
+		delete(pv.Labels, helper.RetainedLabel)
+		_, err = vols.Update(context.TODO(), pv, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to restore the reclaim policy of volume %s: %v", v.NewVolume, err)
+	}
+	v.Phase = drainRebound
+	return nil
+}
+
+// deleteClaimAndWait removes the claim if it exists and waits until it is gone
+func deleteClaimAndWait(clientset *kubernetes.Clientset, namespace string, pvcName string) error {
+	pvcs := clientset.CoreV1().PersistentVolumeClaims(namespace)
+	err := pvcs.Delete(context.TODO(), pvcName, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot remove pvc %s: %v", pvcName, err)
+	}
+	for i := 0; i < 60; i++ {
+		_, err = pvcs.Get(context.TODO(), pvcName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error getting pvc %s: %v", pvcName, err)
+		}
+		time.Sleep(1 * time.Second)
+	}
+	return fmt.Errorf("pvc %s still exists after 60s", pvcName)
+}
+
+// removeDrainSource removes the old persistent volume and, unless kept, its logical volume on the drained node
+func removeDrainSource(clientset *kubernetes.Clientset, e executor.Executor, v *drainVolume) error {
+	if !viper.GetBool("keep-source") {
+		lv, err := lvm.GetLV(e, v.VG, v.LV)
+		if err != nil {
+			return err
+		}
+		if lv != nil {
+			stdout, stderr, err := e.Exec("lvremove -y "+lv.Path(), nil)
+			if err != nil {
+				return fmt.Errorf("unable to remove old volume %s: %v %s %s", lv.Path(), err, stdout, stderr)
+			}
+		}
+	}
+	err := clientset.CoreV1().PersistentVolumes().Delete(context.TODO(), v.Volume, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to remove old volume %s: %v", v.Volume, err)
+	}
+	v.Phase = drainDone
+	return nil
+}
+
+// loadDrainPlan reads the plan file, nil if it does not exist
+func loadDrainPlan(file string) (*drainPlan, error) {
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var plan drainPlan
+	err = json.Unmarshal(content, &plan)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", file, err)
+	}
+	for _, v := range plan.Volumes {
+		switch {
+		case v.Namespace == "" || v.Claim == "" || v.Volume == "" || v.VG == "" || v.LV == "":
+			return nil, fmt.Errorf("plan file %s: claim, volume and logical volume of %s must be set", file, v)
+		case v.TempClaim == "" || v.Target == "" || v.StorageClass == "":
+			return nil, fmt.Errorf("plan file %s: temporary claim, target and storage class of %s must be set", file, v)
+		case v.Request.IsZero():
+			return nil, fmt.Errorf("plan file %s: request of %s must be set", file, v)
+		case v.Phase != drainPending && v.Phase != drainProvisioned && v.Phase != drainCopied && v.Phase != drainRebound && v.Phase != drainDone:
+			return nil, fmt.Errorf("plan file %s: unknown phase %q of %s", file, v.Phase, v)
+		case v.Phase != drainPending && v.NewVolume == "":
+			return nil, fmt.Errorf("plan file %s: new volume of %s must be set in phase %s", file, v, v.Phase)
+		}
+	}
+	return &plan, nil
+}
+
+func saveDrainPlan(file string, plan *drainPlan) error {
+	content, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(file, content, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to save drain plan: %v", err)
+	}
+	return nil
+}
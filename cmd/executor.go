@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// executorPlugin execs into the csi-driver-lvm plugin pod and falls back to executorPod
+	executorPlugin = "plugin"
+	// executorPod always starts a dedicated privileged migrator pod
+	executorPod = "pod"
+	// executorLocal runs the commands on the host csilvmctl is running on
+	executorLocal = "local"
+	// executorSSH runs the commands on the node over ssh
+	executorSSH = "ssh"
+)
+
+var executorModes = []string{executorPlugin, executorPod, executorLocal, executorSSH}
+
+// newExecutor returns the executor selected with --executor for the given node
+func newExecutor(clientset *kubernetes.Clientset, config *restclient.Config, node string, namespace string, podName string) (executor.Executor, error) {
+	return newExecutorMode(viper.GetString("executor"), clientset, config, node, namespace, podName)
+}
+
+func newExecutorMode(mode string, clientset *kubernetes.Clientset, config *restclient.Config, node string, namespace string, podName string) (executor.Executor, error) {
+	switch mode {
+	case executorPlugin:
+		return executor.NewPlugin(clientset, config, node, namespace, podName, viper.GetString("provisioner")), nil
+	case executorPod:
+		return executor.New(clientset, config, node, namespace, podName), nil
+	case executorLocal:
+		if err := checkLocalNode(node); err != nil {
+			return nil, err
+		}
+		return executor.NewLocal(), nil
+	case executorSSH:
+		return executor.NewSSH(sshConfig(node)), nil
+	default:
+		return nil, fmt.Errorf("unknown executor %q, must be one of %v", mode, executorModes)
+	}
+}
+
+// legacyExecutorMode returns the executor mode for steps needing the csi-lvm
+// mounts below /tmp/csi-lvm, which are not visible inside the plugin pod
+func legacyExecutorMode() string {
+	mode := viper.GetString("executor")
+	if mode == executorPlugin {
+		return executorPod
+	}
+	return mode
+}
+
+// checkLocalNode makes sure the local executor is not used for volumes of another node
+func checkLocalNode(node string) error {
+	if node == "" {
+		return nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("unable to determine local hostname: %v", err)
+	}
+	short := func(s string) string {
+		return strings.ToLower(strings.SplitN(s, ".", 2)[0])
+	}
+	if short(hostname) != short(node) {
+		return fmt.Errorf("local executor runs on %s but node %s was requested", hostname, node)
+	}
+	return nil
+}
+
+// sshConfig returns the ssh connection parameters for the node, its address is
+// looked up in the ssh-hosts map of the config file and defaults to the node name
+func sshConfig(node string) executor.SSHConfig {
+	host := node
+	if h := viper.GetStringMapString("ssh-hosts")[strings.ToLower(node)]; h != "" {
+		host = h
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, viper.GetString("ssh-port"))
+	}
+	knownHosts := viper.GetString("ssh-known-hosts")
+	if knownHosts == "" {
+		knownHosts = filepath.Join(homeDir(), ".ssh", "known_hosts")
+	}
+	return executor.SSHConfig{
+		Address:        host,
+		User:           viper.GetString("ssh-user"),
+		IdentityFile:   viper.GetString("ssh-identity"),
+		KnownHostsFile: knownHosts,
+		Timeout:        viper.GetDuration("ssh-timeout"),
+	}
+}
+
+// startExecutor starts an executor on the node for the given purpose
+func startExecutor(clientset *kubernetes.Clientset, config *restclient.Config, node string, namespace string, purpose string) (executor.Executor, error) {
+	e, err := newExecutor(clientset, config, node, namespace, "csilvmctl-"+purpose+"-"+node)
+	if err != nil {
+		return nil, err
+	}
+	err = e.Start()
+	if err != nil {
+		return nil, fmt.Errorf("unable to start executor on node %s: %v", node, err)
+	}
+	err = resolveVGs(clientset, e, node)
+	if err != nil {
+		e.Destroy()
+		return nil, err
+	}
+	return e, nil
+}
+
+// withExecutor starts an executor on the node, runs fn with it and destroys it afterwards
+func withExecutor(clientset *kubernetes.Clientset, config *restclient.Config, node string, namespace string, purpose string, fn func(executor.Executor) error) error {
+	e, err := startExecutor(clientset, config, node, namespace, purpose)
+	if err != nil {
+		return err
+	}
+	defer e.Destroy()
+	return fn(e)
+}
+
+// executorPool holds a started executor per node
+type executorPool map[string]executor.Executor
+
+// startExecutors starts an executor on each of the nodes
+func startExecutors(clientset *kubernetes.Clientset, config *restclient.Config, nodes []string, namespace string, purpose string) (executorPool, error) {
+	pool := executorPool{}
+	for _, node := range nodes {
+		e, err := startExecutor(clientset, config, node, namespace, purpose)
+		if err != nil {
+			pool.Destroy()
+			return nil, err
+		}
+		pool[node] = e
+	}
+	return pool, nil
+}
+
+// Destroy destroys all executors of the pool
+func (p executorPool) Destroy() {
+	for _, e := range p {
+		e.Destroy()
+	}
+}
@@ -0,0 +1,303 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	exportCmd = &cobra.Command{
+		Use:   "export <pvc>",
+		Short: "export a PersistentVolumeClaim to a local archive file",
+		Long: "stream the raw block device or a tar of the filesystem of a snapshot of the logical volume of a PersistentVolumeClaim " +
+			"to a local file and write a sha256 checksum file next to it. Raw exports are transferred in chunks and can be resumed.",
+		PreRun: bindPFlags,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exportVolume(args)
+		},
+	}
+)
+
+func init() {
+	exportCmd.Flags().StringP("output", "o", "", "archive file to write, e.g. db.tar.gz or db.img.zst")
+	exportCmd.Flags().String("format", "", "raw or tar, default is tar for filesystem and raw for block volumes or derived from the file name")
+	exportCmd.Flags().String("compression", "", "none, gzip or zstd, default is derived from the file name")
+	exportCmd.Flags().String("chunk-size", "1Gi", "size of the chunks raw exports are transferred in")
+	exportCmd.Flags().Bool("resume", false, "continue an interrupted raw export")
+	exportCmd.Flags().Duration("interval", 10*time.Second, "interval for reporting the progress")
+}
+
+// exportState is persisted next to the archive while a raw export is running
+type exportState struct {
+	// Snapshot is the logical volume the data is read from
+	Snapshot    string `json:"snapshot"`
+	ChunkSize   uint64 `json:"chunkSize"`
+	Compression string `json:"compression"`
+	// Chunk is the next chunk to transfer
+	Chunk int `json:"chunk"`
+	// Offset is the size of the archive after the last complete chunk
+	Offset int64 `json:"offset"`
+}
+
+func exportVolume(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("no pvc given")
+	}
+	pvcName := args[0]
+	file := viper.GetString("output")
+	if file == "" {
+		return fmt.Errorf("no output file given")
+	}
+
+	clientset, config, namespace, err := newClientset()
+	if err != nil {
+		return err
+	}
+	pvc, pv, node, err := claimVolume(clientset, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+	block := pvc.Spec.VolumeMode != nil && *pvc.Spec.VolumeMode == v1.PersistentVolumeBlock
+
+	format, compression := archiveType(file)
+	if viper.GetString("format") != "" {
+		format = viper.GetString("format")
+	}
+	if format == "" {
+		format = formatTar
+		if block {
+			format = formatRaw
+		}
+	}
+	if viper.GetString("compression") != "" {
+		compression = viper.GetString("compression")
+	}
+	err = checkArchiveType(format, compression)
+	if err != nil {
+		return err
+	}
+	if format == formatTar && block {
+		return fmt.Errorf("pvc %s is a block volume, it can only be exported raw", pvcName)
+	}
+	resume := viper.GetBool("resume")
+	if resume && format != formatRaw {
+		return fmt.Errorf("only raw exports can be resumed")
+	}
+	if _, err := os.Stat(file); err == nil && !resume {
+		if _, err := os.Stat(file + ".progress"); err == nil {
+			return fmt.Errorf("%s is an interrupted export, continue it with --resume", file)
+		}
+		return fmt.Errorf("%s already exists", file)
+	}
+
+	e, err := startExecutor(clientset, config, node, namespace, "export")
+	if err != nil {
+		return err
+	}
+	defer e.Destroy()
+
+	vgname := volumeVG(pv, node)
+	lv, err := lvm.GetLV(e, vgname, volumeLVName(pv))
+	if err != nil {
+		return err
+	}
+	if lv == nil {
+		return fmt.Errorf("logical volume %s not found in volume group %s on node %s", volumeLVName(pv), vgname, node)
+	}
+
+	if format == formatTar {
+		err = exportFiles(e, lv, file, compression)
+	} else {
+		err = exportRaw(e, lv, file, compression, resume)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = writeChecksum(file)
+	if err != nil {
+		return fmt.Errorf("unable to write checksum of %s: %v", file, err)
+	}
+	fmt.Printf("Volume %s successfully exported to %s (%s, compression %s).\n", pvcName, file, format, compression)
+	return nil
+}
+
+// exportFiles writes a tar of the files of a snapshot of the volume to the archive
+func exportFiles(e executor.Executor, lv *lvm.LogicalVolume, file string, compression string) error {
+	fstype, err := filesystemType(e, lv.DevicePath())
+	if err != nil {
+		return err
+	}
+	if fstype == "" {
+		return fmt.Errorf("no filesystem found on %s", lv.DevicePath())
+	}
+	snap, err := createTemporarySnapshot(e, lv, lv.Name+"-export", "")
+	if err != nil {
+		return err
+	}
+	defer removeSnapshot(e, snap)
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	options := ""
+	if fstype == fsXFS {
+		options = "nouuid"
+	}
+	err = withMountedFilesystem(e, snap.DevicePath(), options, func(dir string) error {
+		used, err := mountedUsed(e, dir)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Exporting %s of files of %s\n", formatBytes(used), lv.Path())
+		w := newProgressWriter(f, used, viper.GetDuration("interval"))
+		stderr, err := e.Stream("tar -C "+dir+" -cf - ."+compressCommands[compression], nil, w)
+		if err != nil {
+			return fmt.Errorf("unable to export files of %s: %v %s", lv.Path(), err, stderr)
+		}
+		w.report()
+		return nil
+	})
+	if err != nil {
+		f.Close()
+		os.Remove(file)
+		return err
+	}
+	return f.Close()
+}
+
+// exportRaw writes the block device of a snapshot of the volume chunk by chunk
+// to the archive. The compressed chunks are simply concatenated, which gzip and
+// zstd both decompress as one stream. The snapshot and a state file are kept
+// until the export is complete, so an interrupted export can be resumed.
+func exportRaw(e executor.Executor, lv *lvm.LogicalVolume, file string, compression string, resume bool) error {
+	stateFile := file + ".progress"
+	var state exportState
+	var snap *lvm.LogicalVolume
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if resume {
+		content, err := ioutil.ReadFile(stateFile)
+		if err != nil {
+			return fmt.Errorf("unable to read state of the interrupted export: %v", err)
+		}
+		err = json.Unmarshal(content, &state)
+		if err != nil {
+			return fmt.Errorf("unable to parse %s: %v", stateFile, err)
+		}
+		if state.Compression != compression {
+			return fmt.Errorf("the interrupted export uses compression %s", state.Compression)
+		}
+		snap, err = lvm.GetLV(e, lv.VG, state.Snapshot)
+		if err != nil {
+			return err
+		}
+		if snap == nil || snap.IsInvalid() {
+			return fmt.Errorf("snapshot %s/%s of the interrupted export is gone or invalid, remove %s and %s and start over", lv.VG, state.Snapshot, file, stateFile)
+		}
+		flags = os.O_WRONLY
+	} else {
+		size, err := resource.ParseQuantity(viper.GetString("chunk-size"))
+		if err != nil {
+			return fmt.Errorf("invalid chunk size %s: %v", viper.GetString("chunk-size"), err)
+		}
+		state.ChunkSize = uint64(size.Value())
+		if state.ChunkSize == 0 || state.ChunkSize%(1<<20) != 0 {
+			return fmt.Errorf("chunk size must be a multiple of 1Mi")
+		}
+		snap, err = createTemporarySnapshot(e, lv, lv.Name+"-export", "")
+		if err != nil {
+			return err
+		}
+		state.Snapshot = snap.Name
+		state.Compression = compression
+	}
+
+	f, err := os.OpenFile(file, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// drop the incomplete chunk written when the export was interrupted
+	err = f.Truncate(state.Offset)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(state.Offset, 0)
+	if err != nil {
+		return err
+	}
+
+	blocks := state.ChunkSize >> 20
+	chunks := int((lv.Size + state.ChunkSize - 1) / state.ChunkSize)
+	fmt.Printf("Exporting %s of %s in %d chunks of %s\n", formatBytes(lv.Size), lv.Path(), chunks, formatBytes(state.ChunkSize))
+	interval := viper.GetDuration("interval")
+	last := time.Now()
+	for state.Chunk < chunks {
+		command := "dd if=" + snap.DevicePath() + " bs=1M skip=" + strconv.FormatUint(uint64(state.Chunk)*blocks, 10) +
+			" count=" + strconv.FormatUint(blocks, 10) + " status=none" + compressCommands[compression]
+		stderr, err := e.Stream(command, nil, f)
+		if err == nil {
+			err = f.Sync()
+		}
+		if err != nil {
+			return fmt.Errorf("unable to export chunk %d of %s: %v %s, continue with --resume", state.Chunk+1, lv.Path(), err, stderr)
+		}
+		state.Offset, err = f.Seek(0, 1)
+		if err != nil {
+			return err
+		}
+		state.Chunk++
+		err = saveExportState(stateFile, state)
+		if err != nil {
+			return err
+		}
+		if time.Since(last) >= interval || state.Chunk == chunks {
+			last = time.Now()
+			fmt.Printf("chunk %d of %d exported, %s written\n", state.Chunk, chunks, formatBytes(uint64(state.Offset)))
+		}
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	removeSnapshot(e, snap)
+	return os.Remove(stateFile)
+}
+
+func saveExportState(file string, state exportState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(file, content, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to save export state: %v", err)
+	}
+	return nil
+}
+
+// removeSnapshot removes a temporary snapshot, failures are only reported as
+// the snapshot is removed by cleanup later on
+func removeSnapshot(e executor.Executor, snap *lvm.LogicalVolume) {
+	stdout, stderr, err := e.Exec("lvremove -y "+snap.Path(), nil)
+	if err != nil {
+		fmt.Printf("unable to remove snapshot %s, remove it with csilvmctl cleanup: %v %s %s\n", snap.Path(), err, stdout, stderr)
+	}
+}
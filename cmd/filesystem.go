@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+)
+
+const (
+	fsExt4 = "ext4"
+	fsXFS  = "xfs"
+)
+
+// filesystemType returns the filesystem on the device as detected by blkid,
+// empty if there is none
+func filesystemType(e executor.Executor, device string) (string, error) {
+	// blkid exits with 2 if no filesystem signature is found
+	stdout, stderr, err := e.Exec("blkid -o value -s TYPE "+device+" || [ $? -eq 2 ]", nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to detect filesystem on %s: %v %s %s", device, err, stdout, stderr)
+	}
+	return stdout, nil
+}
+
+// withMountedFilesystem mounts the device with the given options to a temporary directory for fn
+func withMountedFilesystem(e executor.Executor, device string, options string, fn func(dir string) error) error {
+	stdout, stderr, err := e.Exec("mktemp -d /tmp/csilvmctl.XXXXXX", nil)
+	if err != nil {
+		return fmt.Errorf("unable to create mount directory: %v %s %s", err, stdout, stderr)
+	}
+	dir := stdout
+	defer e.Exec("rmdir "+dir, nil)
+
+	if options != "" {
+		options = "-o " + options + " "
+	}
+	stdout, stderr, err = e.Exec("mount "+options+device+" "+dir, nil)
+	if err != nil {
+		return fmt.Errorf("unable to mount %s: %v %s %s", device, err, stdout, stderr)
+	}
+	fnErr := fn(dir)
+	stdout, stderr, err = e.Exec("umount "+dir, nil)
+	if err != nil && fnErr == nil {
+		return fmt.Errorf("unable to umount %s: %v %s %s", device, err, stdout, stderr)
+	}
+	return fnErr
+}
+
+// growFilesystem grows the unmounted filesystem on the device to the size of the device
+func growFilesystem(e executor.Executor, device string, fstype string) error {
+	switch fstype {
+	case "ext2", "ext3", fsExt4:
+		// resize2fs requires a freshly checked filesystem when not mounted
+		stdout, stderr, err := e.Exec("e2fsck -f -p "+device+" && resize2fs "+device, nil)
+		if err != nil {
+			return fmt.Errorf("unable to grow %s filesystem on %s: %v %s %s", fstype, device, err, stdout, stderr)
+		}
+		return nil
+	case fsXFS:
+		// xfs can only be grown while mounted
+		return withMountedFilesystem(e, device, "", func(dir string) error {
+			stdout, stderr, err := e.Exec("xfs_growfs "+dir, nil)
+			if err != nil {
+				return fmt.Errorf("unable to grow xfs filesystem on %s: %v %s %s", device, err, stdout, stderr)
+			}
+			return nil
+		})
+	}
+	return fmt.Errorf("growing %s filesystems is not supported", fstype)
+}
+
+// filesystemUsed returns the bytes used on the filesystem of the device
+func filesystemUsed(e executor.Executor, device string) (uint64, error) {
+	var used uint64
+	err := withMountedFilesystem(e, device, "ro", func(dir string) error {
+		var err error
+		used, err = mountedUsed(e, dir)
+		return err
+	})
+	return used, err
+}
+
+// mountedUsed returns the bytes used by the filesystem mounted at dir
+func mountedUsed(e executor.Executor, dir string) (uint64, error) {
+	stdout, stderr, err := e.Exec("stat -f -c '%S %b %f' "+dir, nil)
+	if err != nil {
+		return 0, fmt.Errorf("unable to get usage of %s: %v %s %s", dir, err, stdout, stderr)
+	}
+	var blockSize, blocks, free uint64
+	_, err = fmt.Sscanf(stdout, "%d %d %d", &blockSize, &blocks, &free)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse usage of %s: %v", dir, err)
+	}
+	return (blocks - free) * blockSize, nil
+}
+
+// fsckResult is the outcome of a filesystem check
+type fsckResult struct {
+	// code is the exit code of e2fsck or xfs_repair
+	code   int
+	output string
+	// clean is true if no errors are left on the filesystem
+	clean bool
+}
+
+// runFilesystemCheck checks the filesystem on the unmounted device, with repair
+// found errors are corrected
+func runFilesystemCheck(e executor.Executor, device string, fstype string, repair bool) (*fsckResult, error) {
+	var command string
+	switch fstype {
+	case "ext2", "ext3", fsExt4:
+		command = "e2fsck -f -n " + device
+		if repair {
+			command = "e2fsck -f -y " + device
+		}
+	case fsXFS:
+		command = "xfs_repair -n " + device
+		if repair {
+			command = "xfs_repair " + device
+		}
+	default:
+		return nil, fmt.Errorf("checking %q filesystems is not supported", fstype)
+	}
+
+	// the exit code is printed last as the executors only report failures
+	stdout, stderr, err := e.Exec(command+" 2>&1; echo $?", nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to check filesystem on %s: %v %s %s", device, err, stdout, stderr)
+	}
+	output := strings.TrimSpace(stdout)
+	lines := strings.Split(output, "\n")
+	code, err := strconv.Atoi(strings.TrimSpace(lines[len(lines)-1]))
+	if err != nil {
+		return nil, fmt.Errorf("unable to get exit code of filesystem check on %s: %s", device, output)
+	}
+	result := &fsckResult{
+		code:   code,
+		output: strings.TrimSpace(strings.Join(lines[:len(lines)-1], "\n")),
+	}
+	switch fstype {
+	case fsXFS:
+		result.clean = code == 0
+	default:
+		// 1 and 2 mean errors were corrected
+		result.clean = code <= 2
+	}
+	return result, nil
+}
+
+// checkFilesystem runs a read-only filesystem check on the unmounted device
+func checkFilesystem(e executor.Executor, device string, fstype string) error {
+	result, err := runFilesystemCheck(e, device, fstype, false)
+	if err != nil {
+		return err
+	}
+	if !result.clean {
+		return fmt.Errorf("filesystem check of %s failed with exit code %d, run fsck --repair: %s", device, result.code, result.output)
+	}
+	return nil
+}
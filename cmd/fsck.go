@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	fsckCmd = &cobra.Command{
+		Use:   "fsck <pvc...>",
+		Short: "check the filesystems of unused PersistentVolumeClaims",
+		Long: "run e2fsck or xfs_repair in check mode on the logical volumes of unused PersistentVolumeClaims, " +
+			"with --repair found errors are corrected",
+		PreRun: bindPFlags,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return fsck(args)
+		},
+	}
+)
+
+func init() {
+	fsckCmd.Flags().Bool("repair", false, "correct found errors")
+}
+
+func fsck(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("no pvc given")
+	}
+	clientset, config, namespace, err := newClientset()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "CLAIM\tNODE\tLV\tFILESYSTEM\tEXIT\tRESULT")
+	failed := 0
+	var details []string
+	for _, pvcName := range args {
+		node, lvPath, fstype, result, err := fsckClaim(clientset, config, namespace, pvcName)
+		switch {
+		case err != nil:
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\t%v\n", pvcName, node, lvPath, fstype, err)
+			failed++
+		case !result.clean:
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", pvcName, node, lvPath, fstype, result.code, "errors found")
+			failed++
+		default:
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", pvcName, node, lvPath, fstype, result.code, "clean")
+		}
+		if result != nil && (!result.clean || viper.GetBool("repair")) {
+			details = append(details, fmt.Sprintf("%s:\n%s\n", pvcName, result.output))
+		}
+	}
+	w.Flush()
+	for _, d := range details {
+		fmt.Println()
+		fmt.Print(d)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d filesystems are not clean or could not be checked", failed, len(args))
+	}
+	return nil
+}
+
+// fsckClaim checks the filesystem of the claim and returns the node, the logical volume and the filesystem checked
+func fsckClaim(clientset *kubernetes.Clientset, config *restclient.Config, namespace string, pvcName string) (string, string, string, *fsckResult, error) {
+	pvc, pv, node, err := claimVolume(clientset, namespace, pvcName)
+	if err != nil {
+		return "", "", "", nil, err
+	}
+	if pvc.Spec.VolumeMode != nil && *pvc.Spec.VolumeMode == v1.PersistentVolumeBlock {
+		return node, "", "", nil, fmt.Errorf("block volume")
+	}
+	err = checkClaimUnused(clientset, namespace, pvcName)
+	if err != nil {
+		return node, "", "", nil, err
+	}
+
+	var lvPath, fstype string
+	var result *fsckResult
+	err = withExecutor(clientset, config, node, namespace, "fsck", func(e executor.Executor) error {
+		vgname := volumeVG(pv, node)
+		lv, err := lvm.GetLV(e, vgname, volumeLVName(pv))
+		if err != nil {
+			return err
+		}
+		if lv == nil {
+			return fmt.Errorf("logical volume %s not found in volume group %s", volumeLVName(pv), vgname)
+		}
+		lvPath = lv.Path()
+		if lv.IsOpen() {
+			return fmt.Errorf("logical volume is still open, e.g. mounted")
+		}
+		fstype, err = filesystemType(e, lv.DevicePath())
+		if err != nil {
+			return err
+		}
+		if fstype == "" {
+			return fmt.Errorf("no filesystem found")
+		}
+		result, err = runFilesystemCheck(e, lv.DevicePath(), fstype, viper.GetBool("repair"))
+		return err
+	})
+	return node, lvPath, fstype, result, err
+}
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/spf13/cobra"
+)
+
+// exit codes of the health command, following the monitoring plugin conventions
+const (
+	healthOK       = 0
+	healthWarning  = 1
+	healthCritical = 2
+	healthUnknown  = 3
+)
+
+var healthNames = map[int]string{
+	healthOK:       "OK",
+	healthWarning:  "WARNING",
+	healthCritical: "CRITICAL",
+	healthUnknown:  "UNKNOWN",
+}
+
+var (
+	healthCmd = &cobra.Command{
+		Use:   "health",
+		Short: "show the health of raid1 mirrored volumes",
+		Long: "show health, sync state and mismatches of all raid volumes on all nodes. " +
+			"Exits with 0 if all mirrors are healthy, 1 if mirrors are syncing or have mismatches, " +
+			"2 if mirrors are degraded and 3 if nodes could not be checked.",
+		PreRun: bindPFlags,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return health()
+		},
+	}
+)
+
+func init() {
+	healthCmd.Flags().StringSlice("node", nil, "nodes to check, default is all nodes with lvm volumes")
+}
+
+func health() error {
+	// without the cluster nothing could be checked, which must not look like a warning
+	clientset, config, namespace, err := newClientset()
+	var pvs []v1.PersistentVolume
+	var nodes []string
+	switch {
+	case apiUnavailable(err):
+		// the local executor checks the mirrors of this host without their claims
+		fmt.Fprintf(os.Stderr, "warning: %v, claims are not shown\n", err)
+		nodes, err = localNodes()
+		if err != nil {
+			return unknownHealth(err)
+		}
+	case err != nil:
+		return unknownHealth(err)
+	default:
+		pvs, err = lvmVolumes(clientset)
+		if err != nil {
+			return unknownHealth(err)
+		}
+		nodes, err = lvmNodes(clientset)
+		if err != nil {
+			return unknownHealth(err)
+		}
+	}
+
+	status := healthOK
+	counts := map[int]int{}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tLV\tNAMESPACE\tCLAIM\tATTR\tSYNC\tMISMATCHES\tSTATUS\tPROBLEM")
+	for _, node := range nodes {
+		var raids []lvm.LogicalVolume
+		err := withExecutor(clientset, config, node, namespace, "health", func(e executor.Executor) error {
+			lvs, err := listNodeLVs(e, node)
+			if err != nil {
+				return err
+			}
+			for _, lv := range lvs {
+				if lv.IsRaid() {
+					raids = append(raids, lv)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(w, "%s\t\t\t\t\t\t\t%s\t%v\n", node, healthNames[healthUnknown], err)
+			status = maxStatus(status, healthUnknown)
+			counts[healthUnknown]++
+			continue
+		}
+
+		for _, lv := range raids {
+			claimNamespace, claim := "", ""
+			if pv := findVolume(pvs, node, lv.Name); pv != nil && pv.Spec.ClaimRef != nil {
+				claimNamespace, claim = pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name
+			}
+			lvStatus, problems := raidHealth(&lv)
+			status = maxStatus(status, lvStatus)
+			counts[lvStatus]++
+			sync := "-"
+			if lv.SyncPercent >= 0 {
+				sync = fmt.Sprintf("%.2f%%", lv.SyncPercent)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", node, lv.Name, claimNamespace, claim, lv.Attr, sync, lv.MismatchCount, healthNames[lvStatus], strings.Join(problems, ", "))
+		}
+	}
+	w.Flush()
+
+	if status == healthOK {
+		return nil
+	}
+	return &exitError{
+		code: status,
+		msg:  fmt.Sprintf("%s: %d degraded, %d warnings, %d nodes not checked", healthNames[status], counts[healthCritical], counts[healthWarning], counts[healthUnknown]),
+	}
+}
+
+// unknownHealth makes the health command exit with UNKNOWN
+func unknownHealth(err error) error {
+	return &exitError{
+		code: healthUnknown,
+		msg:  fmt.Sprintf("%s: %v", healthNames[healthUnknown], err),
+	}
+}
+
+// raidHealth returns the status of a raid volume and the problems found
+func raidHealth(lv *lvm.LogicalVolume) (int, []string) {
+	status := healthOK
+	var problems []string
+	switch lv.HealthStatus {
+	case "":
+	case "mismatches exist":
+		status = maxStatus(status, healthWarning)
+		problems = append(problems, lv.HealthStatus)
+	default:
+		// partial, refresh needed, failed
+		status = maxStatus(status, healthCritical)
+		problems = append(problems, lv.HealthStatus)
+	}
+	if lv.MismatchCount > 0 && lv.HealthStatus != "mismatches exist" {
+		status = maxStatus(status, healthWarning)
+		problems = append(problems, fmt.Sprintf("%d mismatches", lv.MismatchCount))
+	}
+	if lv.SyncPercent >= 0 && lv.SyncPercent < 100 {
+		status = maxStatus(status, healthWarning)
+		problems = append(problems, "out of sync, "+lv.SyncAction)
+	}
+	if !lv.IsActive() {
+		status = maxStatus(status, healthCritical)
+		problems = append(problems, "not active")
+	}
+	return status, problems
+}
+
+func maxStatus(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,314 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+	"github.com/metal-stack/csilvmctl/cmd/internal/helper"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	importCmd = &cobra.Command{
+		Use:   "import <pvc>",
+		Short: "import a local archive file into a PersistentVolumeClaim",
+		Long: "verify the checksum of a raw image or tar archive written by export and stream it into the logical volume " +
+			"of an unused PersistentVolumeClaim. If the claim does not exist, a csi-driver-lvm claim is created on the given node.",
+		PreRun: bindPFlags,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return importVolume(args)
+		},
+	}
+)
+
+func init() {
+	importCmd.Flags().StringP("file", "f", "", "archive file to import, e.g. db.tar.gz or db.img.zst")
+	importCmd.Flags().String("format", "", "raw or tar, default is derived from the file name")
+	importCmd.Flags().String("compression", "", "none, gzip or zstd, default is derived from the file name")
+	importCmd.Flags().Bool("no-verify", false, "import the archive without verifying its checksum file")
+	importCmd.Flags().Bool("replace", false, "remove the existing files of the claim before extracting a tar archive")
+	importCmd.Flags().String("owner", "", "uid:gid to change the ownership of all imported files to")
+	importCmd.Flags().String("mode", "", "permissions of the root directory of the volume, e.g. 0775")
+	importCmd.Flags().String("node", "", "node to create a new claim on")
+	importCmd.Flags().String("storage-class", "", "storage class of a new claim, default is the linear csi-driver-lvm storage class")
+	importCmd.Flags().String("size", "", "size of a new claim, default is the size of an uncompressed raw image")
+	importCmd.Flags().Duration("interval", 10*time.Second, "interval for reporting the progress")
+}
+
+// ownerPattern and modePattern restrict --owner and --mode, they are passed to chown and chmod on the node
+var (
+	ownerPattern = regexp.MustCompile(`^\d+:\d+$`)
+	modePattern  = regexp.MustCompile(`^[0-7]{3,4}$`)
+)
+
+func importVolume(args []string) (err error) {
+	if len(args) < 1 {
+		return fmt.Errorf("no pvc given")
+	}
+	pvcName := args[0]
+	file := viper.GetString("file")
+	if file == "" {
+		return fmt.Errorf("no archive file given")
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	format, compression := archiveType(file)
+	if viper.GetString("format") != "" {
+		format = viper.GetString("format")
+	}
+	if viper.GetString("compression") != "" {
+		compression = viper.GetString("compression")
+	}
+	err = checkArchiveType(format, compression)
+	if err != nil {
+		return err
+	}
+	if owner := viper.GetString("owner"); owner != "" && !ownerPattern.MatchString(owner) {
+		return fmt.Errorf("invalid owner %q, must be uid:gid, e.g. 1000:1000", owner)
+	}
+	if mode := viper.GetString("mode"); mode != "" && !modePattern.MatchString(mode) {
+		return fmt.Errorf("invalid mode %q, must be octal, e.g. 0775", mode)
+	}
+	if !viper.GetBool("no-verify") {
+		fmt.Printf("Verifying checksum of %s\n", file)
+		err = verifyChecksum(file)
+		if err != nil {
+			return fmt.Errorf("%v, use --no-verify to import it anyway", err)
+		}
+	}
+
+	clientset, config, namespace, err := newClientset()
+	if err != nil {
+		return err
+	}
+	pvc, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), pvcName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		pvc, err = createImportClaim(clientset, namespace, pvcName, format, compression, info.Size())
+		if err != nil {
+			return err
+		}
+		// do not leave an empty claim behind if the import fails
+		defer func() {
+			if err != nil {
+				deleteCreatedClaim(clientset, namespace, pvcName)
+			}
+		}()
+	case err != nil:
+		return err
+	default:
+		err = checkClaimUnused(clientset, namespace, pvcName)
+		if err != nil {
+			return err
+		}
+	}
+	block := pvc.Spec.VolumeMode != nil && *pvc.Spec.VolumeMode == v1.PersistentVolumeBlock
+	if block && format == formatTar {
+		return fmt.Errorf("pvc %s is a block volume, only raw images can be imported", pvcName)
+	}
+
+	// claims of storage classes binding on first use get their volume with the first pod
+	if pvc.Spec.VolumeName == "" {
+		if viper.GetString("node") == "" {
+			return fmt.Errorf("pvc %s is not bound, give the node to create its volume on", pvcName)
+		}
+		err = provisionClaim(clientset, viper.GetString("node"), namespace, pvcName)
+		if err != nil {
+			return err
+		}
+	}
+	_, pv, node, err := claimVolume(clientset, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+
+	e, err := startExecutor(clientset, config, node, namespace, "import")
+	if err != nil {
+		return err
+	}
+	defer e.Destroy()
+
+	vgname := volumeVG(pv, node)
+	lv, err := lvm.GetLV(e, vgname, volumeLVName(pv))
+	if err != nil {
+		return err
+	}
+	if lv == nil {
+		// csi-driver-lvm creates the logical volume when the claim is used the first time
+		err = provisionClaim(clientset, node, namespace, pvcName)
+		if err != nil {
+			return err
+		}
+		lv, err = lvm.GetLV(e, vgname, volumeLVName(pv))
+		if err != nil {
+			return err
+		}
+		if lv == nil {
+			return fmt.Errorf("logical volume %s not found in volume group %s on node %s", volumeLVName(pv), vgname, node)
+		}
+	}
+	if lv.IsOpen() {
+		return fmt.Errorf("logical volume %s is still open, e.g. mounted", lv.Path())
+	}
+	if format == formatRaw && compression == compressionNone && uint64(info.Size()) > lv.Size {
+		return fmt.Errorf("image %s with %s does not fit into %s with %s", file, formatBytes(uint64(info.Size())), lv.Path(), formatBytes(lv.Size))
+	}
+
+	fmt.Printf("Importing %s (%s, compression %s) into volume %s (%s) on node %s\n", file, format, compression, pvcName, lv.Path(), node)
+	if !viper.GetBool("yes") {
+		if err := helper.Prompt("Do you want to proceed? (y/n) ", "y"); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := newProgressReader(f, uint64(info.Size()), viper.GetDuration("interval"))
+	if format == formatRaw {
+		stderr, err := e.Stream(decompressCommands[compression]+"dd of="+lv.DevicePath()+" bs=4M conv=fsync status=none", r, nil)
+		if err != nil {
+			return fmt.Errorf("unable to import %s into %s: %v %s", file, lv.Path(), err, stderr)
+		}
+	} else {
+		err = importFiles(e, lv, r, compression)
+		if err != nil {
+			return err
+		}
+	}
+	r.report()
+
+	fmt.Printf("Archive %s successfully imported into volume %s. You can start your pod again.\n", file, pvcName)
+	return nil
+}
+
+// importFiles extracts the tar archive into the filesystem of the volume and fixes the ownership and permissions
+func importFiles(e executor.Executor, lv *lvm.LogicalVolume, r *progressReader, compression string) error {
+	fstype, err := filesystemType(e, lv.DevicePath())
+	if err != nil {
+		return err
+	}
+	if fstype == "" {
+		return fmt.Errorf("no filesystem found on %s", lv.DevicePath())
+	}
+	return withMountedFilesystem(e, lv.DevicePath(), "", func(dir string) error {
+		if viper.GetBool("replace") {
+			stdout, stderr, err := e.Exec("find "+dir+" -mindepth 1 -delete", nil)
+			if err != nil {
+				return fmt.Errorf("unable to remove the files of %s: %v %s %s", lv.Path(), err, stdout, stderr)
+			}
+		}
+		stderr, err := e.Stream(decompressCommands[compression]+"tar -C "+dir+" -xpf -", r, nil)
+		if err != nil {
+			return fmt.Errorf("unable to extract archive into %s: %v %s", lv.Path(), err, stderr)
+		}
+		if owner := viper.GetString("owner"); owner != "" {
+			stdout, stderr, err := e.Exec("chown -R "+owner+" "+dir, nil)
+			if err != nil {
+				return fmt.Errorf("unable to change ownership to %s: %v %s %s", owner, err, stdout, stderr)
+			}
+		}
+		if mode := viper.GetString("mode"); mode != "" {
+			stdout, stderr, err := e.Exec("chmod "+mode+" "+dir, nil)
+			if err != nil {
+				return fmt.Errorf("unable to change permissions to %s: %v %s %s", mode, err, stdout, stderr)
+			}
+		}
+		return nil
+	})
+}
+
+// createImportClaim creates a csi-driver-lvm claim for the archive
+func createImportClaim(clientset *kubernetes.Clientset, namespace string, pvcName string, format string, compression string, fileSize int64) (*v1.PersistentVolumeClaim, error) {
+	storageClass := viper.GetString("storage-class")
+	if storageClass == "" {
+		storageClasses, err := driverStorageClasses(clientset)
+		if err != nil {
+			return nil, err
+		}
+		storageClass = storageClasses["linear"]
+		if storageClass == "" {
+			return nil, fmt.Errorf("no linear csi-driver-lvm storage class found, give one with --storage-class")
+		}
+	}
+	var size resource.Quantity
+	switch {
+	case viper.GetString("size") != "":
+		var err error
+		size, err = resource.ParseQuantity(viper.GetString("size"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid size %s: %v", viper.GetString("size"), err)
+		}
+	case format == formatRaw && compression == compressionNone:
+		size = *resource.NewQuantity(fileSize, resource.BinarySI)
+	default:
+		return nil, fmt.Errorf("pvc %s does not exist, give the size to create it with", pvcName)
+	}
+	if viper.GetString("node") == "" {
+		return nil, fmt.Errorf("pvc %s does not exist, give the node to create it on", pvcName)
+	}
+	var volumeMode *v1.PersistentVolumeMode
+	if format == formatRaw {
+		block := v1.PersistentVolumeBlock
+		volumeMode = &block
+	}
+
+	fmt.Printf("Creating pvc %s with %s of storage class %s on node %s\n", pvcName, size.String(), storageClass, viper.GetString("node"))
+	pvc, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Create(context.TODO(), &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: pvcName,
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			StorageClassName: &storageClass,
+			VolumeMode:       volumeMode,
+			AccessModes: []v1.PersistentVolumeAccessMode{
+				v1.ReadWriteOnce,
+			},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceStorage: size,
+				},
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not create pvc %s: %v", pvcName, err)
+	}
+	return pvc, nil
+}
+
+// deleteCreatedClaim deletes a claim csilvmctl created for a command which failed
+func deleteCreatedClaim(clientset *kubernetes.Clientset, namespace string, pvcName string) {
+	fmt.Printf("Deleting the created pvc %s/%s\n", namespace, pvcName)
+	err := clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(context.TODO(), pvcName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		fmt.Fprintf(os.Stderr, "unable to delete pvc %s: %v\n", pvcName, err)
+	}
+}
+
+// provisionClaim mounts the claim on the node, so the logical volume gets created and formatted
+func provisionClaim(clientset *kubernetes.Clientset, node string, namespace string, pvcName string) error {
+	tempMountPodName := helper.MounterPodPrefix + pvcName
+	err := startMounterPod(clientset, node, namespace, tempMountPodName, pvcName)
+	if err != nil {
+		return err
+	}
+	return helper.DestroyPodAndWait(clientset, namespace, tempMountPodName)
+}
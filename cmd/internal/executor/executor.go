@@ -8,7 +8,6 @@ import (
 	"github.com/metal-stack/csilvmctl/cmd/internal/helper"
 	"github.com/spf13/viper"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/klog"
@@ -18,16 +17,36 @@ import (
 	"k8s.io/client-go/tools/remotecommand"
 )
 
-type Executor struct {
-	podName   string
-	namespace string
-	node      string
-	clientset *kubernetes.Clientset
-	config    *restclient.Config
+// Executor runs shell commands on a node
+type Executor interface {
+	// Start prepares the executor, e.g. by starting a pod on the node
+	Start() error
+	// Exec runs the command in a shell and returns its trimmed stdout and stderr
+	Exec(command string, stdin io.Reader) (string, string, error)
+	// Stream runs the command in a shell, writes its unmodified stdout to the
+	// writer and returns its trimmed stderr
+	Stream(command string, stdin io.Reader, stdout io.Writer) (string, error)
+	// Destroy releases everything created by Start
+	Destroy()
 }
 
-func New(clientset *kubernetes.Clientset, config *restclient.Config, node string, namespace string, podName string) *Executor {
-	return &Executor{
+// PodExecutor runs commands inside a pod on the node. Either a privileged
+// migrator pod is created for that purpose, or the already running
+// csi-driver-lvm plugin pod of the node is reused.
+type PodExecutor struct {
+	podName     string
+	namespace   string
+	container   string
+	node        string
+	provisioner string
+	borrowed    bool
+	clientset   *kubernetes.Clientset
+	config      *restclient.Config
+}
+
+// New returns an executor which always starts a dedicated migrator pod
+func New(clientset *kubernetes.Clientset, config *restclient.Config, node string, namespace string, podName string) *PodExecutor {
+	return &PodExecutor{
 		podName:   podName,
 		namespace: namespace,
 		node:      node,
@@ -36,7 +55,30 @@ func New(clientset *kubernetes.Clientset, config *restclient.Config, node string
 	}
 }
 
-func (e *Executor) Start() error {
+// NewPlugin returns an executor which execs into the csi-driver-lvm plugin pod
+// of the given provisioner on the node and starts a dedicated migrator pod
+// only if no plugin pod is running there
+func NewPlugin(clientset *kubernetes.Clientset, config *restclient.Config, node string, namespace string, podName string, provisioner string) *PodExecutor {
+	e := New(clientset, config, node, namespace, podName)
+	e.provisioner = provisioner
+	return e
+}
+
+func (e *PodExecutor) Start() error {
+	if e.provisioner != "" {
+		pod, container, err := helper.FindPluginPod(e.clientset, e.node, e.provisioner)
+		if err != nil {
+			return err
+		}
+		if pod != nil {
+			e.podName = pod.Name
+			e.namespace = pod.Namespace
+			e.container = container
+			e.borrowed = true
+			return nil
+		}
+		klog.Infof("no plugin pod for %s found on node %s, starting migrator pod", e.provisioner, e.node)
+	}
 
 	hostPathType := v1.HostPathDirectoryOrCreate
 	privileged := true
@@ -50,14 +92,9 @@ func (e *Executor) Start() error {
 		Spec: v1.PodSpec{
 			RestartPolicy: v1.RestartPolicyNever,
 			NodeName:      e.node,
-			Tolerations: []v1.Toleration{
-				{
-					Operator: v1.TolerationOpExists,
-				},
-			},
 			Containers: []v1.Container{
 				{
-					Name:            e.podName,
+					Name:            helper.MigratorPod,
 					Image:           viper.GetString("migrator-pod-image"),
 					ImagePullPolicy: v1.PullIfNotPresent,
 					Command:         []string{"tail", "-f", "/dev/null"},
@@ -96,16 +133,6 @@ func (e *Executor) Start() error {
 					SecurityContext: &v1.SecurityContext{
 						Privileged: &privileged,
 					},
-					Resources: v1.ResourceRequirements{
-						Requests: v1.ResourceList{
-							"cpu":    resource.MustParse("50m"),
-							"memory": resource.MustParse("50Mi"),
-						},
-						Limits: v1.ResourceList{
-							"cpu":    resource.MustParse("100m"),
-							"memory": resource.MustParse("100Mi"),
-						},
-					},
 				},
 			},
 			TerminationGracePeriodSeconds: &terminationGracePeriod,
@@ -168,7 +195,11 @@ func (e *Executor) Start() error {
 		},
 	}
 
-	err := helper.StartPodAndWait(e.clientset, e.namespace, migratorPod)
+	err := helper.ApplyPodTemplate(migratorPod, helper.MigratorPod)
+	if err != nil {
+		return err
+	}
+	err = helper.StartPodAndWait(e.clientset, e.namespace, migratorPod)
 	if err != nil {
 		return err
 	}
@@ -176,7 +207,7 @@ func (e *Executor) Start() error {
 	return nil
 }
 
-func (e *Executor) Exec(command string, stdin io.Reader) (string, string, error) {
+func (e *PodExecutor) Exec(command string, stdin io.Reader) (string, string, error) {
 
 	var stdout, stderr bytes.Buffer
 
@@ -187,11 +218,12 @@ func (e *Executor) Exec(command string, stdin io.Reader) (string, string, error)
 	}
 	req := e.clientset.CoreV1().RESTClient().Post().Resource("pods").Name(e.podName).Namespace(e.namespace).SubResource("exec")
 	option := &v1.PodExecOptions{
-		Command: cmd,
-		Stdin:   true,
-		Stdout:  true,
-		Stderr:  true,
-		TTY:     true,
+		Container: e.container,
+		Command:   cmd,
+		Stdin:     true,
+		Stdout:    true,
+		Stderr:    true,
+		TTY:       true,
 	}
 	if stdin == nil {
 		option.Stdin = false
@@ -215,7 +247,46 @@ func (e *Executor) Exec(command string, stdin io.Reader) (string, string, error)
 	return strings.TrimSpace(stdout.String()), strings.TrimSpace(stderr.String()), nil
 }
 
-func (e *Executor) Destroy() {
+func (e *PodExecutor) Stream(command string, stdin io.Reader, stdout io.Writer) (string, error) {
+
+	var stderr bytes.Buffer
+
+	cmd := []string{
+		"sh",
+		"-c",
+		command,
+	}
+	req := e.clientset.CoreV1().RESTClient().Post().Resource("pods").Name(e.podName).Namespace(e.namespace).SubResource("exec")
+	// without tty the output is passed through unmodified and stderr is kept separate
+	option := &v1.PodExecOptions{
+		Container: e.container,
+		Command:   cmd,
+		Stdin:     stdin != nil,
+		Stdout:    stdout != nil,
+		Stderr:    true,
+		TTY:       false,
+	}
+	req.VersionedParams(
+		option,
+		scheme.ParameterCodec,
+	)
+	exec, err := remotecommand.NewSPDYExecutor(e.config, "POST", req.URL())
+	if err != nil {
+		return "", err
+	}
+	err = exec.Stream(remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: &stderr,
+	})
+	return strings.TrimSpace(stderr.String()), err
+}
+
+func (e *PodExecutor) Destroy() {
+	if e.borrowed {
+		// the plugin pod is managed by its daemonset
+		return
+	}
 	err := helper.DestroyPodAndWait(e.clientset, e.namespace, e.podName)
 	if err != nil {
 		klog.Errorf("unable to delete the migrator pod: %v", err)
@@ -0,0 +1,47 @@
+package executor
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// LocalExecutor runs commands directly on the host csilvmctl is running on
+type LocalExecutor struct{}
+
+// NewLocal returns an executor for the local host
+func NewLocal() *LocalExecutor {
+	return &LocalExecutor{}
+}
+
+func (e *LocalExecutor) Start() error {
+	return nil
+}
+
+func (e *LocalExecutor) Exec(command string, stdin io.Reader) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(stdout.String()), strings.TrimSpace(stderr.String()), nil
+}
+
+func (e *LocalExecutor) Stream(command string, stdin io.Reader, stdout io.Writer) (string, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return strings.TrimSpace(stderr.String()), err
+}
+
+func (e *LocalExecutor) Destroy() {}
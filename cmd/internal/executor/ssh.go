@@ -0,0 +1,158 @@
+package executor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"k8s.io/klog"
+)
+
+// SSHConfig holds the connection parameters of the ssh executor
+type SSHConfig struct {
+	// Address is host:port of the node
+	Address string
+	User    string
+	// IdentityFile is a private key, the ssh agent is used if empty
+	IdentityFile string
+	// KnownHostsFile is used to verify the host key of the node
+	KnownHostsFile string
+	Timeout        time.Duration
+}
+
+// SSHExecutor runs commands on a node over ssh, which also works when the
+// kubelet of the node is down
+type SSHExecutor struct {
+	cfg    SSHConfig
+	client *ssh.Client
+	agent  net.Conn
+}
+
+// NewSSH returns an executor connecting to the node with the given config
+func NewSSH(cfg SSHConfig) *SSHExecutor {
+	return &SSHExecutor{
+		cfg: cfg,
+	}
+}
+
+func (e *SSHExecutor) Start() error {
+	hostKeyCallback, err := knownhosts.New(e.cfg.KnownHostsFile)
+	if err != nil {
+		return fmt.Errorf("unable to read known hosts %s: %v", e.cfg.KnownHostsFile, err)
+	}
+	auth, err := e.authMethod()
+	if err != nil {
+		return err
+	}
+	client, err := ssh.Dial("tcp", e.cfg.Address, &ssh.ClientConfig{
+		User:            e.cfg.User,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         e.cfg.Timeout,
+	})
+	if err != nil {
+		// the agent connection is only needed while authenticating
+		e.closeAgent()
+		return fmt.Errorf("unable to connect to %s: %v", e.cfg.Address, err)
+	}
+	e.client = client
+	return nil
+}
+
+func (e *SSHExecutor) authMethod() (ssh.AuthMethod, error) {
+	if e.cfg.IdentityFile != "" {
+		key, err := ioutil.ReadFile(e.cfg.IdentityFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read identity file %s: %v", e.cfg.IdentityFile, err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse identity file %s: %v", e.cfg.IdentityFile, err)
+		}
+		return ssh.PublicKeys(signer), nil
+	}
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, fmt.Errorf("no identity file given and SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to ssh agent: %v", err)
+	}
+	e.agent = conn
+	return ssh.PublicKeysCallback(agent.NewClient(conn).Signers), nil
+}
+
+func (e *SSHExecutor) Exec(command string, stdin io.Reader) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+
+	if e.client == nil {
+		return "", "", fmt.Errorf("ssh executor for %s not started", e.cfg.Address)
+	}
+	session, err := e.client.NewSession()
+	if err != nil {
+		return "", "", err
+	}
+	defer session.Close()
+
+	session.Stdin = stdin
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	err = session.Run("sh -c " + shellQuote(command))
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(stdout.String()), strings.TrimSpace(stderr.String()), nil
+}
+
+func (e *SSHExecutor) Stream(command string, stdin io.Reader, stdout io.Writer) (string, error) {
+	var stderr bytes.Buffer
+
+	if e.client == nil {
+		return "", fmt.Errorf("ssh executor for %s not started", e.cfg.Address)
+	}
+	session, err := e.client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = &stderr
+	err = session.Run("sh -c " + shellQuote(command))
+	return strings.TrimSpace(stderr.String()), err
+}
+
+func (e *SSHExecutor) Destroy() {
+	if e.client != nil {
+		if err := e.client.Close(); err != nil {
+			klog.Errorf("unable to close ssh connection to %s: %v", e.cfg.Address, err)
+		}
+	}
+	e.client = nil
+	e.closeAgent()
+}
+
+// closeAgent closes the connection to the ssh agent if one was opened
+func (e *SSHExecutor) closeAgent() {
+	if e.agent != nil {
+		if err := e.agent.Close(); err != nil {
+			klog.Errorf("unable to close ssh agent connection: %v", err)
+		}
+		e.agent = nil
+	}
+}
+
+// shellQuote quotes s for use as a single argument of a posix shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
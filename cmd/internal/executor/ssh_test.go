@@ -0,0 +1,307 @@
+package executor
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSSHServer is an ssh server on localhost running exec requests with the local shell
+type testSSHServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+}
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func startTestSSHServer(t *testing.T, hostKey ssh.Signer, clientKey ssh.PublicKey) *testSSHServer {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, os.ErrPermission
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSSHServer{listener: listener, config: config}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *testSSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *testSSHServer) handle(conn net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go handleSession(channel, requests)
+	}
+}
+
+func handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			req.Reply(false, nil)
+			return
+		}
+		req.Reply(true, nil)
+
+		cmd := exec.Command("sh", "-c", payload.Command)
+		cmd.Stdin = channel
+		cmd.Stdout = channel
+		cmd.Stderr = channel.Stderr()
+		status := uint32(0)
+		if err := cmd.Run(); err != nil {
+			status = 255
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				status = uint32(exitErr.ExitCode())
+			}
+		}
+		exitStatus := make([]byte, 4)
+		binary.BigEndian.PutUint32(exitStatus, status)
+		channel.SendRequest("exit-status", false, exitStatus)
+		return
+	}
+}
+
+// newTestSSHExecutor starts a server and returns an executor trusting the given host key
+func newTestSSHExecutor(t *testing.T, trustedHostKey ssh.PublicKey) *SSHExecutor {
+	dir, err := ioutil.TempDir("", "ssh-executor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSigner, err := ssh.NewSignerFromKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner := newTestSigner(t)
+	if trustedHostKey == nil {
+		trustedHostKey = hostSigner.PublicKey()
+	}
+	server := startTestSSHServer(t, hostSigner, clientSigner.PublicKey())
+	address := server.listener.Addr().String()
+
+	identityFile := filepath.Join(dir, "id_ecdsa")
+	err = ioutil.WriteFile(identityFile, marshalECPrivateKey(t, clientKey), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(address)}, trustedHostKey)
+	err = ioutil.WriteFile(knownHostsFile, []byte(line+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSSH(SSHConfig{
+		Address:        address,
+		User:           "root",
+		IdentityFile:   identityFile,
+		KnownHostsFile: knownHostsFile,
+		Timeout:        5 * time.Second,
+	})
+}
+
+func TestSSHExec(t *testing.T) {
+	e := newTestSSHExecutor(t, nil)
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer e.Destroy()
+
+	tests := []struct {
+		name       string
+		command    string
+		stdin      string
+		wantStdout string
+		wantStderr string
+	}{
+		{name: "stdout", command: "echo hello", wantStdout: "hello"},
+		{name: "stderr", command: "echo oops >&2", wantStderr: "oops"},
+		{name: "stdin", command: "tr a-z A-Z", stdin: "lvm\n", wantStdout: "LVM"},
+		{name: "quotes", command: `echo "it's" '$HOME'`, wantStdout: "it's $HOME"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, err := e.Exec(tt.command, strings.NewReader(tt.stdin))
+			if err != nil {
+				t.Fatalf("Exec() error = %v", err)
+			}
+			if stdout != tt.wantStdout {
+				t.Errorf("Exec() stdout = %q, want %q", stdout, tt.wantStdout)
+			}
+			if stderr != tt.wantStderr {
+				t.Errorf("Exec() stderr = %q, want %q", stderr, tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestSSHStream(t *testing.T) {
+	e := newTestSSHExecutor(t, nil)
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer e.Destroy()
+
+	var stdout bytes.Buffer
+	stderr, err := e.Stream("cat; echo done >&2", strings.NewReader("  raw\ndata\n"), &stdout)
+	if err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	if stdout.String() != "  raw\ndata\n" {
+		t.Errorf("Stream() stdout = %q, want the unmodified input", stdout.String())
+	}
+	if stderr != "done" {
+		t.Errorf("Stream() stderr = %q, want %q", stderr, "done")
+	}
+}
+
+func TestSSHExitStatus(t *testing.T) {
+	e := newTestSSHExecutor(t, nil)
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer e.Destroy()
+
+	_, _, err := e.Exec("exit 5", nil)
+	exitErr, ok := err.(*ssh.ExitError)
+	if !ok {
+		t.Fatalf("Exec() error = %v, want an exit error", err)
+	}
+	if exitErr.ExitStatus() != 5 {
+		t.Errorf("Exec() exit status = %d, want 5", exitErr.ExitStatus())
+	}
+
+	_, err = e.Stream("exit 3", nil, ioutil.Discard)
+	exitErr, ok = err.(*ssh.ExitError)
+	if !ok || exitErr.ExitStatus() != 3 {
+		t.Errorf("Stream() error = %v, want exit status 3", err)
+	}
+}
+
+func TestSSHKnownHostsMismatch(t *testing.T) {
+	e := newTestSSHExecutor(t, newTestSigner(t).PublicKey())
+	err := e.Start()
+	if err == nil {
+		e.Destroy()
+		t.Fatal("Start() succeeded with an unknown host key")
+	}
+	if !strings.Contains(err.Error(), "key mismatch") {
+		t.Errorf("Start() error = %v, want a key mismatch", err)
+	}
+	if e.client != nil || e.agent != nil {
+		t.Errorf("Start() left connections open after failing")
+	}
+}
+
+// marshalECPrivateKey encodes the key as pem like ssh-keygen -m pem does
+func marshalECPrivateKey(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func TestSSHAgentClosedOnStartError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssh-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	closed := make(chan struct{})
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		// ServeAgent returns once the client closed the connection
+		agent.ServeAgent(agent.NewKeyring(), conn)
+		close(closed)
+	}()
+	defer os.Setenv("SSH_AUTH_SOCK", os.Getenv("SSH_AUTH_SOCK"))
+	os.Setenv("SSH_AUTH_SOCK", socket)
+
+	e := newTestSSHExecutor(t, newTestSigner(t).PublicKey())
+	e.cfg.IdentityFile = ""
+	if err := e.Start(); err == nil {
+		e.Destroy()
+		t.Fatal("Start() succeeded with an unknown host key")
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Error("Start() did not close the ssh agent connection")
+	}
+}
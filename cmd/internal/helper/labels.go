@@ -0,0 +1,32 @@
+package helper
+
+const (
+	// ManagedByLabel is set on every object created by csilvmctl, objects
+	// carrying it are temporary and removed by the cleanup command
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	// ManagedByValue is the value of ManagedByLabel
+	ManagedByValue = "csilvmctl"
+	// ComponentLabel tells what an object created by csilvmctl is used for
+	ComponentLabel = "app.kubernetes.io/component"
+	// RetainedLabel marks persistent volumes whose reclaim policy was set to
+	// Retain by csilvmctl
+	RetainedLabel = "csilvmctl.metal-stack.io/retained"
+
+	// MigratorPod is the privileged pod lvm commands are executed in
+	MigratorPod = "migrator"
+	// MounterPod is the pod mounting a new claim to get its volume provisioned
+	MounterPod = "mounter"
+
+	// MigratorPodPrefix and MounterPodPrefix are the name prefixes of the pods
+	// created by migrate before they were labeled
+	MigratorPodPrefix = "csi-lvm-migrator-pod-"
+	MounterPodPrefix  = "temp-mountpod-"
+)
+
+// ManagedByLabels returns the labels for an object created by csilvmctl
+func ManagedByLabels(component string) map[string]string {
+	return map[string]string{
+		ManagedByLabel: ManagedByValue,
+		ComponentLabel: component,
+	}
+}
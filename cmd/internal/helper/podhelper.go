@@ -55,3 +55,75 @@ func DestroyPodAndWait(clientset *kubernetes.Clientset, namespace string, podNam
 	}
 	return fmt.Errorf("unable to delete the migrator pod: %v", err)
 }
+
+// FindPluginPod returns a running csi-driver-lvm plugin pod on the given node and
+// the name of its plugin container. The plugin container is identified by its
+// --drivername argument, nil is returned if no such pod exists.
+func FindPluginPod(clientset *kubernetes.Clientset, node string, provisioner string) (*v1.Pod, string, error) {
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: "spec.nodeName=" + node + ",status.phase=" + string(v1.PodRunning),
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to list pods on node %s: %v", node, err)
+	}
+	for i := range pods.Items {
+		p := &pods.Items[i]
+		if p.DeletionTimestamp != nil {
+			continue
+		}
+		for _, c := range p.Spec.Containers {
+			if isPluginContainer(c, provisioner) {
+				return p, c.Name, nil
+			}
+		}
+	}
+	return nil, "", nil
+}
+
+func isPluginContainer(c v1.Container, provisioner string) bool {
+	args := append(append([]string{}, c.Command...), c.Args...)
+	for i, a := range args {
+		if a == "--drivername="+provisioner {
+			return true
+		}
+		if a == "--drivername" && i+1 < len(args) && args[i+1] == provisioner {
+			return true
+		}
+	}
+	return false
+}
+
+// FindPluginNodes returns the names of all nodes running a csi-driver-lvm plugin pod
+func FindPluginNodes(clientset *kubernetes.Clientset, provisioner string) ([]string, error) {
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: "status.phase=" + string(v1.PodRunning),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list pods: %v", err)
+	}
+	var nodes []string
+	for _, p := range pods.Items {
+		for _, c := range p.Spec.Containers {
+			if isPluginContainer(c, provisioner) && p.Spec.NodeName != "" {
+				nodes = append(nodes, p.Spec.NodeName)
+				break
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// VolumeNode returns the node a local persistent volume is located on
+func VolumeNode(pv *v1.PersistentVolume) string {
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		return ""
+	}
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		for _, expr := range term.MatchExpressions {
+			if len(expr.Values) > 0 {
+				return expr.Values[0]
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package helper
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/viper"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultPodTemplate holds the strategic merge patches applied to the pods
+// created by csilvmctl before the ones given with --pod-template
+const DefaultPodTemplate = `
+migrator:
+  spec:
+    tolerations:
+    - operator: Exists
+    containers:
+    - name: migrator
+      resources:
+        requests:
+          cpu: 50m
+          memory: 50Mi
+        limits:
+          cpu: 100m
+          memory: 100Mi
+mounter:
+  spec:
+    tolerations:
+    - operator: Exists
+`
+
+// podTemplate contains a strategic merge patch per pod kind
+type podTemplate map[string]json.RawMessage
+
+// ApplyPodTemplate labels the pod as created by csilvmctl and patches it with
+// the default template and the template file given with --pod-template
+func ApplyPodTemplate(pod *v1.Pod, kind string) error {
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	pod.Labels[ManagedByLabel] = ManagedByValue
+	pod.Labels[ComponentLabel] = kind
+
+	templates := []string{DefaultPodTemplate}
+	if path := viper.GetString("pod-template"); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("unable to read pod template %s: %v", path, err)
+		}
+		templates = append(templates, string(data))
+	}
+
+	for _, t := range templates {
+		var tpl podTemplate
+		err := yaml.Unmarshal([]byte(t), &tpl)
+		if err != nil {
+			return fmt.Errorf("invalid pod template: %v", err)
+		}
+		patch, ok := tpl[kind]
+		if !ok {
+			continue
+		}
+		err = patchPod(pod, patch)
+		if err != nil {
+			return fmt.Errorf("unable to apply %s pod template: %v", kind, err)
+		}
+	}
+	return nil
+}
+
+func patchPod(pod *v1.Pod, patch []byte) error {
+	original, err := json.Marshal(pod)
+	if err != nil {
+		return err
+	}
+	patched, err := strategicpatch.StrategicMergePatch(original, patch, v1.Pod{})
+	if err != nil {
+		return err
+	}
+	*pod = v1.Pod{}
+	return json.Unmarshal(patched, pod)
+}
@@ -0,0 +1,221 @@
+package lvm
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+)
+
+// lvColumns are the fields requested from lvs
+var lvColumns = []string{
+	"lv_name",
+	"vg_name",
+	"lv_size",
+	"lv_layout",
+	"lv_tags",
+	"lv_attr",
+	"lv_time",
+	"devices",
+	"sync_percent",
+	"lv_health_status",
+	"raid_sync_action",
+	"raid_mismatch_count",
+	"origin",
+	"data_percent",
+	"pool_lv",
+	"metadata_percent",
+}
+
+// LogicalVolume is a row of the lvs report
+type LogicalVolume struct {
+	Name string
+	VG   string
+	// Size in bytes
+	Size uint64
+	// Layout is lv_layout split at commas, e.g. [raid raid1]
+	Layout []string
+	Tags   []string
+	Attr   string
+	Time   time.Time
+	// Devices hold the extents of the volume, these are physical volumes for
+	// linear and striped volumes and the hidden sub volumes for raid volumes
+	Devices []string
+	// SyncPercent is -1 for volumes which are not mirrored
+	SyncPercent float64
+	// HealthStatus is empty for healthy volumes, otherwise e.g. partial,
+	// refresh needed or mismatches exist
+	HealthStatus string
+	// SyncAction is the current raid sync operation, e.g. idle, resync, check or repair
+	SyncAction string
+	// MismatchCount is the number of inconsistencies found by the last raid check
+	MismatchCount uint64
+	// Origin is the volume a snapshot was taken of
+	Origin string
+	// DataPercent is the usage of the copy-on-write space of snapshots and of
+	// the data space of thin pools and thin volumes, -1 if not reported
+	DataPercent float64
+	// PoolLV is the thin pool of thin volumes
+	PoolLV string
+	// MetadataPercent is the usage of the metadata space of thin pools, -1 if not reported
+	MetadataPercent float64
+}
+
+// devicePattern matches the extent range in the devices field, e.g. /dev/sda(0)
+var devicePattern = regexp.MustCompile(`\(\d+\)$`)
+
+func newLogicalVolume(row map[string]string) LogicalVolume {
+	lv := LogicalVolume{
+		Name:            row["lv_name"],
+		VG:              row["vg_name"],
+		Size:            parseUint(row["lv_size"]),
+		Layout:          parseList(row["lv_layout"]),
+		Tags:            parseList(row["lv_tags"]),
+		Attr:            row["lv_attr"],
+		Time:            parseTime(row["lv_time"]),
+		SyncPercent:     parsePercent(row["sync_percent"]),
+		HealthStatus:    row["lv_health_status"],
+		SyncAction:      row["raid_sync_action"],
+		MismatchCount:   parseUint(row["raid_mismatch_count"]),
+		Origin:          row["origin"],
+		DataPercent:     parsePercent(row["data_percent"]),
+		PoolLV:          row["pool_lv"],
+		MetadataPercent: parsePercent(row["metadata_percent"]),
+	}
+	for _, d := range parseList(row["devices"]) {
+		lv.Devices = append(lv.Devices, devicePattern.ReplaceAllString(d, ""))
+	}
+	return lv
+}
+
+// ListLVs returns all logical volumes of the volume group, of all volume groups if vg is empty
+func ListLVs(e executor.Executor, vg string) ([]LogicalVolume, error) {
+	rows, err := run(e, "lvs "+reportOptions+" -o "+strings.Join(lvColumns, ",")+" "+vg, "lv")
+	if err != nil {
+		return nil, err
+	}
+	var lvs []LogicalVolume
+	for _, row := range rows {
+		lvs = append(lvs, newLogicalVolume(row))
+	}
+	return mergeSegments(lvs), nil
+}
+
+// GetLV returns the logical volume of the volume group with the given name or nil if it does not exist
+func GetLV(e executor.Executor, vg string, name string) (*LogicalVolume, error) {
+	lvs, err := ListLVs(e, vg)
+	if err != nil {
+		return nil, err
+	}
+	for i := range lvs {
+		if lvs[i].Name == name {
+			return &lvs[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// mergeSegments joins the rows lvs reports per segment when devices are requested
+func mergeSegments(lvs []LogicalVolume) []LogicalVolume {
+	var result []LogicalVolume
+	index := map[string]int{}
+	for _, lv := range lvs {
+		key := lv.VG + "/" + lv.Name
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(result)
+			result = append(result, lv)
+			continue
+		}
+		for _, d := range lv.Devices {
+			if !contains(result[i].Devices, d) {
+				result[i].Devices = append(result[i].Devices, d)
+			}
+		}
+	}
+	return result
+}
+
+// HasTag returns true if the volume carries the tag
+func (lv *LogicalVolume) HasTag(tag string) bool {
+	return contains(lv.Tags, tag)
+}
+
+// HasLayout returns true if lv_layout contains the given layout, e.g. raid1
+func (lv *LogicalVolume) HasLayout(layout string) bool {
+	return contains(lv.Layout, layout)
+}
+
+// Type returns the csi-driver-lvm volume type matching the layout of the
+// volume: linear, striped or mirror. Raid1 volumes are created for the
+// mirror type. Thin volumes are of type thin, their pools of type thin-pool.
+func (lv *LogicalVolume) Type() string {
+	switch {
+	case lv.IsThinPool():
+		return "thin-pool"
+	case lv.IsThin():
+		return "thin"
+	case lv.HasLayout("raid1"), lv.HasLayout("mirror"):
+		return "mirror"
+	case lv.HasLayout("striped"):
+		return "striped"
+	case lv.HasLayout("linear"):
+		return "linear"
+	}
+	return strings.Join(lv.Layout, ",")
+}
+
+// IsRaid returns true for raid volumes
+func (lv *LogicalVolume) IsRaid() bool {
+	return lv.HasLayout("raid")
+}
+
+// IsThinPool returns true for thin pools
+func (lv *LogicalVolume) IsThinPool() bool {
+	return lv.HasLayout("thin") && lv.HasLayout("pool")
+}
+
+// IsThin returns true for thin volumes, which allocate their extents from a thin pool
+func (lv *LogicalVolume) IsThin() bool {
+	return lv.HasLayout("thin") && !lv.HasLayout("pool")
+}
+
+// IsSnapshot returns true for snapshots
+func (lv *LogicalVolume) IsSnapshot() bool {
+	return lv.Origin != ""
+}
+
+// IsInvalid returns true for snapshots which ran out of space
+func (lv *LogicalVolume) IsInvalid() bool {
+	return len(lv.Attr) > 4 && lv.Attr[4] == 'I'
+}
+
+// IsActive returns true if the device of the volume is active
+func (lv *LogicalVolume) IsActive() bool {
+	return len(lv.Attr) > 4 && lv.Attr[4] == 'a'
+}
+
+// IsOpen returns true if the device of the volume is in use, e.g. mounted
+func (lv *LogicalVolume) IsOpen() bool {
+	return len(lv.Attr) > 5 && lv.Attr[5] == 'o'
+}
+
+// Path returns the vg/lv notation used by the lvm commands
+func (lv *LogicalVolume) Path() string {
+	return lv.VG + "/" + lv.Name
+}
+
+// DevicePath returns the block device of the volume
+func (lv *LogicalVolume) DevicePath() string {
+	return "/dev/" + lv.VG + "/" + lv.Name
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+package lvm
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// lvsCommand is the lvs call listing the csi-lvm volume group
+const lvsCommand = "lvs --reportformat json --units b --nosuffix -o lv_name,vg_name,lv_size,lv_layout,lv_tags,lv_attr,lv_time," +
+	"devices,sync_percent,lv_health_status,raid_sync_action,raid_mismatch_count,origin,data_percent,pool_lv,metadata_percent csi-lvm"
+
+func TestListLVs(t *testing.T) {
+	e := newFakeExecutor(t, map[string]string{lvsCommand: "lvs.json"})
+	lvs, err := ListLVs(e, "csi-lvm")
+	if err != nil {
+		t.Fatalf("ListLVs() error = %v", err)
+	}
+
+	want := []LogicalVolume{
+		{
+			Name:            "pvc-1a2b",
+			VG:              "csi-lvm",
+			Size:            10737418240,
+			Layout:          []string{"linear"},
+			Tags:            []string{DriverTag, ClaimTag("default", "my-db")},
+			Attr:            "-wi-ao----",
+			Time:            time.Date(2020, 7, 1, 8, 15, 42, 0, time.UTC),
+			Devices:         []string{"/dev/nvme0n1", "/dev/nvme1n1"},
+			SyncPercent:     -1,
+			DataPercent:     -1,
+			MetadataPercent: -1,
+		},
+		{
+			Name:            "pvc-3c4d",
+			VG:              "csi-lvm",
+			Size:            5368709120,
+			Layout:          []string{"raid", "raid1"},
+			Tags:            []string{DriverTag},
+			Attr:            "rwi-aor-r-",
+			Time:            time.Date(2020, 7, 2, 6, 0, 0, 0, time.UTC),
+			Devices:         []string{"pvc-3c4d_rimage_0", "pvc-3c4d_rimage_1"},
+			SyncPercent:     37.5,
+			HealthStatus:    "refresh needed",
+			SyncAction:      "recover",
+			MismatchCount:   12,
+			DataPercent:     -1,
+			MetadataPercent: -1,
+		},
+		{
+			Name:            "pvc-5e6f",
+			VG:              "csi-lvm",
+			Size:            2147483648,
+			Layout:          []string{"striped"},
+			Tags:            []string{DriverTag},
+			Attr:            "-wi-a-----",
+			Time:            time.Date(2020, 7, 3, 12, 30, 0, 0, time.UTC),
+			Devices:         []string{"/dev/nvme0n1", "/dev/nvme1n1"},
+			SyncPercent:     -1,
+			DataPercent:     -1,
+			MetadataPercent: -1,
+		},
+		{
+			Name:            "pool0",
+			VG:              "csi-lvm",
+			Size:            53687091200,
+			Layout:          []string{"thin", "pool"},
+			Attr:            "twi-aotz--",
+			Time:            time.Date(2020, 7, 4, 7, 0, 0, 0, time.UTC),
+			Devices:         []string{"pool0_tdata"},
+			SyncPercent:     -1,
+			DataPercent:     86.31,
+			MetadataPercent: 12.05,
+		},
+		{
+			Name:            "pvc-7a8b",
+			VG:              "csi-lvm",
+			Size:            107374182400,
+			Layout:          []string{"thin", "sparse"},
+			Tags:            []string{DriverTag},
+			Attr:            "Vwi-aotz--",
+			Time:            time.Date(2020, 7, 4, 7, 5, 0, 0, time.UTC),
+			SyncPercent:     -1,
+			DataPercent:     41.2,
+			PoolLV:          "pool0",
+			MetadataPercent: -1,
+		},
+		{
+			Name:            "pvc-1a2b-snap",
+			VG:              "csi-lvm",
+			Size:            1073741824,
+			Layout:          []string{"linear"},
+			Tags:            []string{TemporaryTag},
+			Attr:            "swi-I-s---",
+			Time:            time.Date(2020, 7, 5, 16, 0, 0, 0, time.UTC),
+			Devices:         []string{"/dev/nvme0n1"},
+			SyncPercent:     -1,
+			Origin:          "pvc-1a2b",
+			DataPercent:     100,
+			MetadataPercent: -1,
+		},
+	}
+	if len(lvs) != len(want) {
+		t.Fatalf("ListLVs() returned %d volumes, want %d", len(lvs), len(want))
+	}
+	for i := range want {
+		// compare the times separately, their locations differ
+		if !lvs[i].Time.Equal(want[i].Time) {
+			t.Errorf("ListLVs()[%d].Time = %v, want %v", i, lvs[i].Time, want[i].Time)
+		}
+		lvs[i].Time = want[i].Time
+		if !reflect.DeepEqual(lvs[i], want[i]) {
+			t.Errorf("ListLVs()[%d] = %+v, want %+v", i, lvs[i], want[i])
+		}
+	}
+}
+
+func TestGetLV(t *testing.T) {
+	e := newFakeExecutor(t, map[string]string{lvsCommand: "lvs.json"})
+	lv, err := GetLV(e, "csi-lvm", "pool0")
+	if err != nil {
+		t.Fatalf("GetLV() error = %v", err)
+	}
+	if lv == nil || lv.Name != "pool0" {
+		t.Errorf("GetLV() = %v, want pool0", lv)
+	}
+	lv, err = GetLV(e, "csi-lvm", "missing")
+	if err != nil || lv != nil {
+		t.Errorf("GetLV() = %v, %v, want nil for a missing volume", lv, err)
+	}
+}
+
+func TestLogicalVolumeType(t *testing.T) {
+	tests := []struct {
+		layout       string
+		wantType     string
+		wantRaid     bool
+		wantThin     bool
+		wantThinPool bool
+	}{
+		{layout: "linear", wantType: "linear"},
+		{layout: "striped", wantType: "striped"},
+		{layout: "raid,raid1", wantType: "mirror", wantRaid: true},
+		{layout: "mirror", wantType: "mirror"},
+		{layout: "thin,pool", wantType: "thin-pool", wantThinPool: true},
+		{layout: "thin,sparse", wantType: "thin", wantThin: true},
+		{layout: "raid,raid5,raid5_ls", wantType: "raid,raid5,raid5_ls", wantRaid: true},
+		{layout: "cache", wantType: "cache"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			lv := LogicalVolume{Layout: parseList(tt.layout)}
+			if got := lv.Type(); got != tt.wantType {
+				t.Errorf("Type() = %q, want %q", got, tt.wantType)
+			}
+			if got := lv.IsRaid(); got != tt.wantRaid {
+				t.Errorf("IsRaid() = %v, want %v", got, tt.wantRaid)
+			}
+			if got := lv.IsThin(); got != tt.wantThin {
+				t.Errorf("IsThin() = %v, want %v", got, tt.wantThin)
+			}
+			if got := lv.IsThinPool(); got != tt.wantThinPool {
+				t.Errorf("IsThinPool() = %v, want %v", got, tt.wantThinPool)
+			}
+		})
+	}
+}
+
+func TestLogicalVolumeAttr(t *testing.T) {
+	tests := []struct {
+		attr        string
+		wantActive  bool
+		wantOpen    bool
+		wantInvalid bool
+	}{
+		{attr: "-wi-ao----", wantActive: true, wantOpen: true},
+		{attr: "-wi-a-----", wantActive: true},
+		{attr: "-wi-------"},
+		{attr: "swi-I-s---", wantInvalid: true},
+		{attr: ""},
+	}
+	for _, tt := range tests {
+		lv := LogicalVolume{Attr: tt.attr}
+		if got := lv.IsActive(); got != tt.wantActive {
+			t.Errorf("IsActive() of %q = %v, want %v", tt.attr, got, tt.wantActive)
+		}
+		if got := lv.IsOpen(); got != tt.wantOpen {
+			t.Errorf("IsOpen() of %q = %v, want %v", tt.attr, got, tt.wantOpen)
+		}
+		if got := lv.IsInvalid(); got != tt.wantInvalid {
+			t.Errorf("IsInvalid() of %q = %v, want %v", tt.attr, got, tt.wantInvalid)
+		}
+	}
+}
+
+func TestLogicalVolumePaths(t *testing.T) {
+	lv := LogicalVolume{Name: "pvc-1a2b", VG: "csi-lvm", Tags: []string{DriverTag}}
+	if got := lv.Path(); got != "csi-lvm/pvc-1a2b" {
+		t.Errorf("Path() = %q", got)
+	}
+	if got := lv.DevicePath(); got != "/dev/csi-lvm/pvc-1a2b" {
+		t.Errorf("DevicePath() = %q", got)
+	}
+	if !lv.HasTag(DriverTag) || lv.HasTag(LegacyTag) {
+		t.Errorf("HasTag() does not match the tags %q", lv.Tags)
+	}
+	if lv.IsSnapshot() {
+		t.Errorf("IsSnapshot() = true for a volume without origin")
+	}
+}
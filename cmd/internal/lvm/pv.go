@@ -0,0 +1,106 @@
+package lvm
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+)
+
+// pvColumns are the fields requested from pvs
+var pvColumns = []string{
+	"pv_name",
+	"vg_name",
+	"pv_size",
+	"pv_free",
+	"pv_attr",
+}
+
+// PhysicalVolume is a row of the pvs report
+type PhysicalVolume struct {
+	Name string
+	// VG is empty if the physical volume is not part of a volume group
+	VG string
+	// Size and Free in bytes
+	Size uint64
+	Free uint64
+	Attr string
+}
+
+// ListPVs returns all physical volumes of the node
+func ListPVs(e executor.Executor) ([]PhysicalVolume, error) {
+	rows, err := run(e, "pvs "+reportOptions+" -o "+strings.Join(pvColumns, ","), "pv")
+	if err != nil {
+		return nil, err
+	}
+	var pvs []PhysicalVolume
+	for _, row := range rows {
+		pvs = append(pvs, PhysicalVolume{
+			Name: row["pv_name"],
+			VG:   row["vg_name"],
+			Size: parseUint(row["pv_size"]),
+			Free: parseUint(row["pv_free"]),
+			Attr: row["pv_attr"],
+		})
+	}
+	return pvs, nil
+}
+
+// ListVGPVs returns the physical volumes of the volume group
+func ListVGPVs(e executor.Executor, vg string) ([]PhysicalVolume, error) {
+	pvs, err := ListPVs(e)
+	if err != nil {
+		return nil, err
+	}
+	var result []PhysicalVolume
+	for _, pv := range pvs {
+		if pv.VG == vg {
+			result = append(result, pv)
+		}
+	}
+	return result, nil
+}
+
+// Segment is a range of extents of a physical volume
+type Segment struct {
+	PV string
+	// LV is the top level logical volume the extents belong to, empty for free extents
+	LV string
+	// Extents is the length of the segment in extents of the volume group
+	Extents uint64
+}
+
+// subVolumePattern matches the hidden sub volumes of raid, mirror and thin pool volumes, e.g. [lv_rimage_0]
+var subVolumePattern = regexp.MustCompile(`^\[?(.+?)_(rimage|rmeta|mimage|mlog|tdata|tmeta|pmspare)(_\d+)?\]?$`)
+
+// ListSegments returns the segments of the physical volumes of the volume group
+func ListSegments(e executor.Executor, vg string) ([]Segment, error) {
+	// pvs takes physical volumes as arguments, not volume groups
+	rows, err := run(e, "pvs --segments "+reportOptions+" -o pv_name,vg_name,lv_name,pvseg_size", "pv")
+	if err != nil {
+		return nil, err
+	}
+	var segments []Segment
+	for _, row := range rows {
+		if row["vg_name"] != vg {
+			continue
+		}
+		lv := strings.Trim(subVolumePattern.ReplaceAllString(row["lv_name"], "$1"), "[]")
+		segments = append(segments, Segment{
+			PV:      row["pv_name"],
+			LV:      lv,
+			Extents: parseUint(row["pvseg_size"]),
+		})
+	}
+	return segments, nil
+}
+
+// IsAllocatable returns true if new extents may be allocated on the physical volume
+func (pv *PhysicalVolume) IsAllocatable() bool {
+	return len(pv.Attr) > 0 && pv.Attr[0] == 'a'
+}
+
+// IsMissing returns true if the device of the physical volume is missing
+func (pv *PhysicalVolume) IsMissing() bool {
+	return len(pv.Attr) > 2 && pv.Attr[2] == 'm'
+}
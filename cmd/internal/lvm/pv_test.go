@@ -0,0 +1,106 @@
+package lvm
+
+import (
+	"reflect"
+	"testing"
+)
+
+// pvsCommand lists all physical volumes
+const pvsCommand = "pvs --reportformat json --units b --nosuffix -o pv_name,vg_name,pv_size,pv_free,pv_attr"
+
+func TestListVGPVs(t *testing.T) {
+	e := newFakeExecutor(t, map[string]string{pvsCommand: "pvs.json"})
+	pvs, err := ListVGPVs(e, "csi-lvm")
+	if err != nil {
+		t.Fatalf("ListVGPVs() error = %v", err)
+	}
+	want := []PhysicalVolume{
+		{Name: "/dev/nvme0n1", VG: "csi-lvm", Size: 959925190656, Free: 691489734656, Attr: "a--"},
+		{Name: "/dev/nvme1n1", VG: "csi-lvm", Size: 959925190656, Free: 691489734656, Attr: "a-m"},
+	}
+	if !reflect.DeepEqual(pvs, want) {
+		t.Errorf("ListVGPVs() = %+v, want %+v", pvs, want)
+	}
+
+	all, err := ListPVs(e)
+	if err != nil {
+		t.Fatalf("ListPVs() error = %v", err)
+	}
+	if len(all) != 4 || all[3].VG != "" {
+		t.Errorf("ListPVs() = %+v, want all 4 physical volumes including the unused /dev/sdb", all)
+	}
+}
+
+func TestPhysicalVolumeAttr(t *testing.T) {
+	tests := []struct {
+		attr            string
+		wantAllocatable bool
+		wantMissing     bool
+	}{
+		{attr: "a--", wantAllocatable: true},
+		{attr: "a-m", wantAllocatable: true, wantMissing: true},
+		{attr: "---"},
+		{attr: ""},
+	}
+	for _, tt := range tests {
+		pv := PhysicalVolume{Attr: tt.attr}
+		if got := pv.IsAllocatable(); got != tt.wantAllocatable {
+			t.Errorf("IsAllocatable() of %q = %v, want %v", tt.attr, got, tt.wantAllocatable)
+		}
+		if got := pv.IsMissing(); got != tt.wantMissing {
+			t.Errorf("IsMissing() of %q = %v, want %v", tt.attr, got, tt.wantMissing)
+		}
+	}
+}
+
+func TestListSegments(t *testing.T) {
+	e := newFakeExecutor(t, map[string]string{
+		"pvs --segments --reportformat json --units b --nosuffix -o pv_name,vg_name,lv_name,pvseg_size": "pvs-segments.json",
+	})
+	segments, err := ListSegments(e, "csi-lvm")
+	if err != nil {
+		t.Fatalf("ListSegments() error = %v", err)
+	}
+	want := []Segment{
+		{PV: "/dev/nvme0n1", LV: "pvc-1a2b", Extents: 2560},
+		{PV: "/dev/nvme0n1", LV: "pvc-3c4d", Extents: 1},
+		{PV: "/dev/nvme0n1", LV: "pvc-3c4d", Extents: 1280},
+		{PV: "/dev/nvme0n1", LV: "pool0", Extents: 12800},
+		{PV: "/dev/nvme0n1", LV: "pvmove0", Extents: 512},
+		{PV: "/dev/nvme0n1", LV: "", Extents: 164863},
+		{PV: "/dev/nvme1n1", LV: "pvc-3c4d", Extents: 1},
+		{PV: "/dev/nvme1n1", LV: "pvc-3c4d", Extents: 1280},
+		{PV: "/dev/nvme1n1", LV: "pool0", Extents: 13},
+		{PV: "/dev/nvme1n1", LV: "lvol0", Extents: 13},
+		{PV: "/dev/nvme1n1", LV: "pvc-1a2b", Extents: 512},
+	}
+	if !reflect.DeepEqual(segments, want) {
+		t.Errorf("ListSegments() = %+v, want %+v", segments, want)
+	}
+}
+
+func TestSubVolumePattern(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "[pvc-3c4d_rimage_0]", want: "pvc-3c4d"},
+		{name: "[pvc-3c4d_rmeta_1]", want: "pvc-3c4d"},
+		{name: "pvc-3c4d_rimage_0", want: "pvc-3c4d"},
+		{name: "[legacy_mimage_1]", want: "legacy"},
+		{name: "[legacy_mlog]", want: "legacy"},
+		{name: "[pool0_tdata]", want: "pool0"},
+		{name: "[pool0_tmeta]", want: "pool0"},
+		{name: "[lvol0_pmspare]", want: "lvol0"},
+		{name: "[my_data_rimage_0]", want: "my_data"},
+		// pvmove volumes and plain names are not sub volumes
+		{name: "[pvmove0]", want: "[pvmove0]"},
+		{name: "pvc-1a2b", want: "pvc-1a2b"},
+		{name: "pvc-1a2b_snapshot", want: "pvc-1a2b_snapshot"},
+	}
+	for _, tt := range tests {
+		if got := subVolumePattern.ReplaceAllString(tt.name, "$1"); got != tt.want {
+			t.Errorf("subVolumePattern replaced %q with %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package lvm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+)
+
+// reportOptions are passed to every lvs, vgs and pvs call, sizes are reported in bytes
+const reportOptions = "--reportformat json --units b --nosuffix"
+
+// timeLayout is the format of lv_time
+const timeLayout = "2006-01-02 15:04:05 -0700"
+
+// report is the json output of lvs, vgs and pvs
+type report struct {
+	Report []struct {
+		LV []map[string]string `json:"lv"`
+		VG []map[string]string `json:"vg"`
+		PV []map[string]string `json:"pv"`
+	} `json:"report"`
+}
+
+// run executes an lvm reporting command and returns the rows of the given section
+func run(e executor.Executor, command string, section string) ([]map[string]string, error) {
+	stdout, stderr, err := e.Exec(command, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %v %s %s", command, err, stdout, stderr)
+	}
+	// warnings may precede the report if stdout and stderr are merged by a tty
+	start := strings.Index(stdout, "{")
+	end := strings.LastIndex(stdout, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("%s returned no report: %s %s", command, stdout, stderr)
+	}
+	var r report
+	err = json.Unmarshal([]byte(stdout[start:end+1]), &r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse report of %s: %v", command, err)
+	}
+	var rows []map[string]string
+	for _, part := range r.Report {
+		switch section {
+		case "lv":
+			rows = append(rows, part.LV...)
+		case "vg":
+			rows = append(rows, part.VG...)
+		case "pv":
+			rows = append(rows, part.PV...)
+		}
+	}
+	return rows, nil
+}
+
+func parseUint(s string) uint64 {
+	v, _ := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+	return v
+}
+
+// parsePercent returns -1 if the percentage is not reported for the object
+func parsePercent(s string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return -1
+	}
+	return v
+}
+
+func parseList(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func parseTime(s string) time.Time {
+	t, _ := time.Parse(timeLayout, strings.TrimSpace(s))
+	return t
+}
@@ -0,0 +1,184 @@
+package lvm
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeExecutor answers lvm commands with captured reports, the key is the
+// complete command line, so tests fail on wrong arguments
+type fakeExecutor struct {
+	outputs map[string]string
+}
+
+func newFakeExecutor(t *testing.T, files map[string]string) *fakeExecutor {
+	e := &fakeExecutor{outputs: map[string]string{}}
+	for command, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.outputs[command] = string(data)
+	}
+	return e
+}
+
+func (e *fakeExecutor) Start() error { return nil }
+
+func (e *fakeExecutor) Exec(command string, stdin io.Reader) (string, string, error) {
+	output, ok := e.outputs[command]
+	if !ok {
+		return "", "unexpected command", fmt.Errorf("exit status 3")
+	}
+	return strings.TrimSpace(output), "", nil
+}
+
+func (e *fakeExecutor) Stream(command string, stdin io.Reader, stdout io.Writer) (string, error) {
+	return "", fmt.Errorf("not implemented")
+}
+
+func (e *fakeExecutor) Destroy() {}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		section  string
+		wantRows int
+		wantErr  bool
+	}{
+		{
+			name:     "lv section",
+			output:   `{"report":[{"lv":[{"lv_name":"a"},{"lv_name":"b"}]}]}`,
+			section:  "lv",
+			wantRows: 2,
+		},
+		{
+			name:     "other section is empty",
+			output:   `{"report":[{"lv":[{"lv_name":"a"}]}]}`,
+			section:  "vg",
+			wantRows: 0,
+		},
+		{
+			name:     "several reports",
+			output:   `{"report":[{"pv":[{"pv_name":"/dev/sda"}]},{"pv":[{"pv_name":"/dev/sdb"}]}]}`,
+			section:  "pv",
+			wantRows: 2,
+		},
+		{
+			name: "warnings merged by a tty",
+			output: "  WARNING: Device /dev/sdc has size of 0 sectors.\n" +
+				`{"report":[{"vg":[{"vg_name":"csi-lvm"}]}]}` + "\n  WARNING: done",
+			section:  "vg",
+			wantRows: 1,
+		},
+		{
+			name:    "no report",
+			output:  "  Volume group \"csi-lvm\" not found",
+			section: "vg",
+			wantErr: true,
+		},
+		{
+			name:    "invalid json",
+			output:  `{"report":[{"vg":[{"vg_name":}]}]}`,
+			section: "vg",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &fakeExecutor{outputs: map[string]string{"vgs " + reportOptions: tt.output}}
+			rows, err := run(e, "vgs "+reportOptions, tt.section)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(rows) != tt.wantRows {
+				t.Errorf("run() returned %d rows, want %d", len(rows), tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	e := &fakeExecutor{outputs: map[string]string{}}
+	_, err := run(e, "lvs "+reportOptions, "lv")
+	if err == nil || !strings.Contains(err.Error(), "unexpected command") {
+		t.Errorf("run() error = %v, want the stderr of the failed command", err)
+	}
+}
+
+func TestParseUint(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint64
+	}{
+		{"10737418240", 10737418240},
+		{" 42 ", 42},
+		{"", 0},
+		{"-1", 0},
+		{"12.5", 0},
+	}
+	for _, tt := range tests {
+		if got := parseUint(tt.in); got != tt.want {
+			t.Errorf("parseUint(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParsePercent(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"100.00", 100},
+		{"37.50", 37.5},
+		{" 0.00 ", 0},
+		{"", -1},
+		{"-", -1},
+	}
+	for _, tt := range tests {
+		if got := parsePercent(tt.in); got != tt.want {
+			t.Errorf("parsePercent(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseList(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"raid,raid1", []string{"raid", "raid1"}},
+		{"linear", []string{"linear"}},
+		{"", nil},
+		{"  ", nil},
+	}
+	for _, tt := range tests {
+		got := parseList(tt.in)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("parseList(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"2020-07-01 10:15:42 +0200", time.Date(2020, 7, 1, 8, 15, 42, 0, time.UTC)},
+		{" 2020-07-03 12:30:00 +0000 ", time.Date(2020, 7, 3, 12, 30, 0, 0, time.UTC)},
+		{"", time.Time{}},
+		{"yesterday", time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := parseTime(tt.in); !got.Equal(tt.want) {
+			t.Errorf("parseTime(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package lvm
+
+const (
+	// LegacyTag marks logical volumes created by csi-lvm
+	LegacyTag = "lv.metal-stack.io/csi-lvm"
+	// DriverTag marks logical volumes created by csi-driver-lvm
+	DriverTag = "vg.metal-stack.io/csi-lvm-driver"
+	// TemporaryTag marks logical volumes only needed while a csilvmctl command is running
+	TemporaryTag = "lv.metal-stack.io/csilvmctl-temporary"
+	// SnapshotTag marks snapshots created by csilvmctl
+	SnapshotTag = "lv.metal-stack.io/csilvmctl-snapshot"
+	// ClaimTagPrefix is followed by namespace/name of the claim a snapshot was taken of
+	ClaimTagPrefix = "lv.metal-stack.io/pvc="
+)
+
+// ClaimTag returns the tag identifying the claim a snapshot was taken of
+func ClaimTag(namespace string, name string) string {
+	return ClaimTagPrefix + namespace + "/" + name
+}
@@ -0,0 +1,70 @@
+package lvm
+
+import (
+	"strings"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+)
+
+// vgColumns are the fields requested from vgs
+var vgColumns = []string{
+	"vg_name",
+	"vg_size",
+	"vg_free",
+	"vg_tags",
+	"pv_count",
+	"lv_count",
+	"vg_extent_size",
+}
+
+// VolumeGroup is a row of the vgs report
+type VolumeGroup struct {
+	Name string
+	// Size and Free in bytes
+	Size       uint64
+	Free       uint64
+	ExtentSize uint64
+	Tags       []string
+	PVCount    uint64
+	LVCount    uint64
+}
+
+// ListVGs returns all volume groups of the node
+func ListVGs(e executor.Executor) ([]VolumeGroup, error) {
+	rows, err := run(e, "vgs "+reportOptions+" -o "+strings.Join(vgColumns, ","), "vg")
+	if err != nil {
+		return nil, err
+	}
+	var vgs []VolumeGroup
+	for _, row := range rows {
+		vgs = append(vgs, VolumeGroup{
+			Name:       row["vg_name"],
+			Size:       parseUint(row["vg_size"]),
+			Free:       parseUint(row["vg_free"]),
+			ExtentSize: parseUint(row["vg_extent_size"]),
+			Tags:       parseList(row["vg_tags"]),
+			PVCount:    parseUint(row["pv_count"]),
+			LVCount:    parseUint(row["lv_count"]),
+		})
+	}
+	return vgs, nil
+}
+
+// HasTag returns true if the volume group carries the tag
+func (vg *VolumeGroup) HasTag(tag string) bool {
+	return contains(vg.Tags, tag)
+}
+
+// GetVG returns the volume group with the given name or nil if it does not exist
+func GetVG(e executor.Executor, name string) (*VolumeGroup, error) {
+	vgs, err := ListVGs(e)
+	if err != nil {
+		return nil, err
+	}
+	for i := range vgs {
+		if vgs[i].Name == name {
+			return &vgs[i], nil
+		}
+	}
+	return nil, nil
+}
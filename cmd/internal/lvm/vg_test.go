@@ -0,0 +1,36 @@
+package lvm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetVG(t *testing.T) {
+	e := newFakeExecutor(t, map[string]string{
+		"vgs --reportformat json --units b --nosuffix -o vg_name,vg_size,vg_free,vg_tags,pv_count,lv_count,vg_extent_size": "vgs.json",
+	})
+	vg, err := GetVG(e, "csi-lvm")
+	if err != nil {
+		t.Fatalf("GetVG() error = %v", err)
+	}
+	want := &VolumeGroup{
+		Name:       "csi-lvm",
+		Size:       1919850381312,
+		Free:       1382979469312,
+		ExtentSize: 4194304,
+		Tags:       []string{DriverTag},
+		PVCount:    2,
+		LVCount:    6,
+	}
+	if !reflect.DeepEqual(vg, want) {
+		t.Errorf("GetVG() = %+v, want %+v", vg, want)
+	}
+	if !vg.HasTag(DriverTag) {
+		t.Errorf("HasTag(%q) = false", DriverTag)
+	}
+
+	vg, err = GetVG(e, "missing")
+	if err != nil || vg != nil {
+		t.Errorf("GetVG() = %v, %v, want nil for a missing volume group", vg, err)
+	}
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+	"github.com/metal-stack/csilvmctl/cmd/internal/helper"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	lvCmd = &cobra.Command{
+		Use:   "lv",
+		Short: "manage logical volumes",
+	}
+	lvListCmd = &cobra.Command{
+		Use:    "list",
+		Short:  "list logical volumes on the nodes with their PersistentVolumes and PersistentVolumeClaims",
+		Long:   "list all logical volumes of the volume group on the nodes and the PersistentVolumes and PersistentVolumeClaims they back",
+		PreRun: bindPFlags,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listLVs()
+		},
+	}
+)
+
+func init() {
+	lvListCmd.Flags().StringSlice("node", nil, "nodes to list the logical volumes of, default is all nodes with lvm volumes")
+	lvCmd.AddCommand(lvListCmd)
+}
+
+// nodeLV is a logical volume on a node together with its persistent volume
+type nodeLV struct {
+	node string
+	lv   lvm.LogicalVolume
+	pv   *v1.PersistentVolume
+}
+
+func listLVs() error {
+	clientset, config, namespace, err := newClientset()
+	offline := apiUnavailable(err)
+	if err != nil && !offline {
+		return err
+	}
+	var pvs []v1.PersistentVolume
+	var nodes []string
+	existingClaims := map[string]bool{}
+	if offline {
+		// only the logical volumes of this host are known
+		fmt.Fprintf(os.Stderr, "warning: %v, persistent volumes and claims are not shown\n", err)
+		nodes, err = localNodes()
+		if err != nil {
+			return err
+		}
+	} else {
+		pvs, err = lvmVolumes(clientset)
+		if err != nil {
+			return err
+		}
+		claims, err := clientset.CoreV1().PersistentVolumeClaims(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to list persistent volume claims: %v", err)
+		}
+		for _, pvc := range claims.Items {
+			existingClaims[pvc.Namespace+"/"+pvc.Name] = true
+		}
+		nodes, err = lvmNodes(clientset)
+		if err != nil {
+			return err
+		}
+	}
+
+	var result []nodeLV
+	for _, node := range nodes {
+		err := withExecutor(clientset, config, node, namespace, "lv-list", func(e executor.Executor) error {
+			lvs, err := listNodeLVs(e, node)
+			if err != nil {
+				return fmt.Errorf("unable to list logical volumes on node %s: %v", node, err)
+			}
+			for _, lv := range lvs {
+				result = append(result, nodeLV{
+					node: node,
+					lv:   lv,
+					pv:   findVolume(pvs, node, lv.Name),
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tVG\tLV\tTYPE\tSIZE\tDRIVER\tPV\tNAMESPACE\tCLAIM")
+	for _, r := range result {
+		pv, namespace, claim := "<none>", "", ""
+		if offline {
+			pv = "<unknown>"
+		}
+		if r.pv != nil {
+			pv = r.pv.Name
+			if ref := r.pv.Spec.ClaimRef; ref != nil {
+				namespace, claim = ref.Namespace, ref.Name
+				if !existingClaims[namespace+"/"+claim] {
+					claim += " (missing)"
+				}
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.node, r.lv.VG, r.lv.Name, r.lv.Type(), formatBytes(r.lv.Size), lvDriver(&r.lv), pv, namespace, claim)
+	}
+	return w.Flush()
+}
+
+// findVolume returns the persistent volume backed by the logical volume on the node
+func findVolume(pvs []v1.PersistentVolume, node string, lvName string) *v1.PersistentVolume {
+	for i := range pvs {
+		if volumeLVName(&pvs[i]) == lvName && helper.VolumeNode(&pvs[i]) == node {
+			return &pvs[i]
+		}
+	}
+	return nil
+}
+
+// lvDriver tells by its tags which driver created the logical volume
+func lvDriver(lv *lvm.LogicalVolume) string {
+	switch {
+	case lv.HasTag(lvm.DriverTag):
+		return "csi-driver-lvm"
+	case lv.HasTag(lvm.LegacyTag):
+		return "csi-lvm"
+	case lv.HasTag(lvm.TemporaryTag), lv.HasTag(lvm.SnapshotTag):
+		return "csilvmctl"
+	}
+	return "-"
+}
+
+func formatBytes(b uint64) string {
+	return resource.NewQuantity(int64(b), resource.BinarySI).String()
+}
@@ -3,32 +3,26 @@ package cmd
 import (
 	"context"
 	"fmt"
-	"strings"
-	"time"
 
-	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
 	"github.com/metal-stack/csilvmctl/cmd/internal/helper"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/retry"
-	"k8s.io/klog"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-
-	// needed for kubectl auth
-	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
 )
 
 var (
 	migrateCmd = &cobra.Command{
 		Use:   "migrate",
 		Short: "migrate a csi-lvm PersistentVolumeClaim to csi-driver-lvm",
-		Long:  "migrate a csi-lvm PersistentVolumeClaim to csi-driver-lvm",
+		Long: "migrate a csi-lvm PersistentVolumeClaim to csi-driver-lvm. If csi-lvm and csi-driver-lvm use different volume groups, " +
+			"the logical volume is moved with vgsplit when its physical volumes hold no other volumes, otherwise its data is copied.",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return migrateVolume(args)
 		},
@@ -36,6 +30,7 @@ var (
 )
 
 func init() {
+	migrateCmd.Flags().String("move-strategy", moveAuto, "how to move volumes between volume groups: auto, vgsplit or copy")
 	viper.BindPFlags(migrateCmd.Flags())
 }
 
@@ -45,32 +40,15 @@ func migrateVolume(args []string) error {
 	}
 	pvcName := args[0]
 
-	// use the current context in kubeconfig
-	config, err := clientcmd.BuildConfigFromFlags("", viper.GetString("kubeconfig"))
-	if err != nil {
-		panic(err.Error())
-	}
-	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
-	configOverrides := &clientcmd.ConfigOverrides{}
-	kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
-	namespace, _, _ := kubeConfig.Namespace()
-	if viper.GetString("namespace") != "" {
-		namespace = viper.GetString("namespace")
-	}
-
-	// create the clientset
-	clientset, err := kubernetes.NewForConfig(config)
+	clientset, config, namespace, err := newClientset()
 	if err != nil {
-		panic(err.Error())
+		return err
 	}
 
 	// get existing csi-driver-lvm storage classes
-	storageClasses := make(map[string]string)
-	scs, err := clientset.StorageV1().StorageClasses().List(context.TODO(), metav1.ListOptions{})
-	for _, s := range scs.Items {
-		if s.Provisioner == viper.GetString("provisioner") {
-			storageClasses[s.Parameters["type"]] = s.Name
-		}
+	storageClasses, err := driverStorageClasses(clientset)
+	if err != nil {
+		return err
 	}
 
 	// get pvc api object
@@ -89,14 +67,18 @@ func migrateVolume(args []string) error {
 	originalSize := resource.NewQuantity(s, resource.BinarySI).String()
 
 	// get node where the volume is located
-	node := oldVolume.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Values[0]
-	if err != nil {
-		klog.Fatal(err)
+	node := helper.VolumeNode(oldVolume)
+	if node == "" {
+		return fmt.Errorf("old volume %s has no node affinity", oldVolumeName)
 	}
 
-	// start our migrator pod on that volume
-	migratorPod := executor.New(clientset, config, node, namespace, "csi-lvm-migrator-pod-"+pvcName)
-	migratorPod.Start()
+	// start our migrator pod on that volume, the legacy csi-lvm mounts below
+	// /tmp/csi-lvm are not visible inside the csi-driver-lvm plugin pod
+	migratorPod, err := newExecutorMode(legacyExecutorMode(), clientset, config, node, namespace, helper.MigratorPodPrefix+pvcName)
+	if err != nil {
+		return err
+	}
+	err = migratorPod.Start()
 	if err != nil {
 		return err
 	}
@@ -105,54 +87,70 @@ func migrateVolume(args []string) error {
 		migratorPod.Destroy()
 	}()
 
-	// check if volume group exists
-	vgname := viper.GetString("vgname")
-	stdout, stderr, err := migratorPod.Exec("vgs --no-headings -o vg_name "+vgname, nil)
+	// check if volume groups exist
+	err = resolveVGs(clientset, migratorPod, node)
 	if err != nil {
 		return err
 	}
-	if stdout != vgname {
-		return fmt.Errorf("volume group %s not found: %s %s", vgname, stdout, stderr)
+	sourceVG, vgname := legacyVG(node), driverVG(node)
+	for _, name := range nodeVGs(node) {
+		vg, err := lvm.GetVG(migratorPod, name)
+		if err != nil {
+			return err
+		}
+		if vg == nil {
+			return fmt.Errorf("volume group %s not found on node %s", name, node)
+		}
 	}
 
 	// check if volume is an csi-lvm volume
-	stdout, stderr, err = migratorPod.Exec("lvs --no-headings -o lv_tags "+vgname+"/"+oldVolumeName, nil)
+	oldLV, err := lvm.GetLV(migratorPod, sourceVG, oldVolumeName)
 	if err != nil {
 		return err
 	}
-	if !strings.Contains(stdout, "lv.metal-stack.io/csi-lvm") {
-		return fmt.Errorf("volume %s is not of type csi-lvm (does not contain tag \"lv.metal-stack.io/csi-lvm\"", oldVolumeName)
+	if oldLV == nil {
+		return fmt.Errorf("logical volume %s not found in volume group %s on node %s", oldVolumeName, sourceVG, node)
 	}
-
-	// get lvmType of the volume
-	lvmType, stderr, err := migratorPod.Exec("lvs --no-headings -o lv_layout "+vgname+"/"+oldVolumeName, nil)
-	if err != nil {
-		return err
-	}
-	if lvmType == "raid,raid1" {
-		lvmType = "mirror"
+	if !oldLV.HasTag(lvm.LegacyTag) {
+		return fmt.Errorf("volume %s is not of type csi-lvm (does not contain tag %q)", oldVolumeName, lvm.LegacyTag)
 	}
 
 	// find new storage class
+	lvmType := oldLV.Type()
 	newStorageClass := storageClasses[lvmType]
+	if newStorageClass == "" && oldLV.IsThin() {
+		return fmt.Errorf("volume %s is a thin volume in pool %s, no csi-driver-lvm storage class of type thin found", oldVolumeName, oldLV.PoolLV)
+	}
 	if newStorageClass == "" {
-		return fmt.Errorf("no matching csi-driver-lvm storage class found for type %s %s", lvmType, stderr)
+		return fmt.Errorf("no matching csi-driver-lvm storage class found for type %s", lvmType)
+	}
+	warning, err := thinPoolWarning(migratorPod, oldLV)
+	if err != nil {
+		return err
+	}
+	if warning != "" {
+		fmt.Printf("warning: %s\n", warning)
 	}
 
 	// check for running pods
-	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+	err = checkClaimUnused(clientset, namespace, pvcName)
 	if err != nil {
 		return err
 	}
-	for _, p := range pods.Items {
-		for _, v := range p.Spec.Volumes {
-			if v.PersistentVolumeClaim != nil && v.PersistentVolumeClaim.ClaimName == pvcName {
-				return fmt.Errorf("error: pvc %s is in use by pod %s", pvcName, p.GetName())
-			}
+
+	// lvrename cannot move volumes to another volume group
+	var move *volumeMove
+	if sourceVG != vgname {
+		move, err = planMove(migratorPod, oldLV, vgname, viper.GetString("move-strategy"))
+		if err != nil {
+			return err
 		}
 	}
 
 	fmt.Printf("Migrating volume %s (%s) on node %s to new storage class %s\n", pvc.GetName(), oldVolumeName, node, newStorageClass)
+	if move != nil {
+		fmt.Printf("The volume will be moved to volume group %s: %s\n", vgname, move)
+	}
 	if !viper.GetBool("yes") {
 		if err := helper.Prompt("Do you want to proceed? (y/n) ", "y"); err != nil {
 			return err
@@ -160,71 +158,25 @@ func migrateVolume(args []string) error {
 	}
 	fmt.Println("Please wait ...")
 
-	// set volume to retain
-	err = setVolumeToRetain(clientset, oldVolumeName)
-	if err != nil {
-		return err
-	}
-
-	// delete old pvc
-	err = clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(context.TODO(), pvcName, metav1.DeleteOptions{})
-	if err != nil {
-		return fmt.Errorf("cannot remove pvc %s: %s", pvcName, err)
+	umount := func() error {
+		stdout, stderr, err := migratorPod.Exec("umount /tmp/csi-lvm/"+oldVolumeName, nil)
+		if err != nil {
+			return fmt.Errorf("unable to umount volume %s: %s %s %s", oldVolumeName, err, stdout, stderr)
+		}
+		return nil
 	}
-
-	// wait till pvc is gone
-	retrySeconds := 60
-	for i := 0; i < retrySeconds; i++ {
-		tp, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), pvcName, metav1.GetOptions{})
-		if tp != nil && tp.ObjectMeta.Name != pvcName {
-			break
+	lvName := oldLV.Name
+	if move != nil {
+		err = umount()
+		if err != nil {
+			return err
 		}
+		lvName, err = move.run(migratorPod)
 		if err != nil {
-			return fmt.Errorf("error getting pvc %v: %v", pvcName, err)
+			return err
 		}
-		time.Sleep(1 * time.Second)
-	}
-
-	// create new pvc
-	_, err = clientset.CoreV1().PersistentVolumeClaims(namespace).Create(context.TODO(), &v1.PersistentVolumeClaim{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: pvcName,
-		},
-		Spec: v1.PersistentVolumeClaimSpec{
-			StorageClassName: &newStorageClass,
-			VolumeMode:       pvc.Spec.VolumeMode,
-			AccessModes: []v1.PersistentVolumeAccessMode{
-				v1.ReadWriteOnce,
-			},
-			Resources: v1.ResourceRequirements{
-				Requests: v1.ResourceList{
-					v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Mi"),
-				},
-			},
-		},
-	}, metav1.CreateOptions{})
-	if err != nil {
-		return fmt.Errorf("could not create the new pvc: %s", err)
-	}
-
-	// create dummy pod so that the lvm volume gets actually crated on the target node
-	tempMountPodName := "temp-mountpod-" + pvcName
-	err = startMounterPod(clientset, node, namespace, tempMountPodName, pvcName)
-	if err != nil {
-		return err
-	}
-
-	// get new pv name
-	pvc, err = clientset.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), pvcName, metav1.GetOptions{})
-	if err != nil {
-		return err
-	}
-	newVolumeName := pvc.Spec.VolumeName
-
-	// delete dummy pod
-	err = helper.DestroyPodAndWait(clientset, namespace, tempMountPodName)
-	if err != nil {
-		return err
+		// the volume is already unmounted
+		umount = nil
 	}
 
 	// move volume
@@ -233,38 +185,45 @@ func migrateVolume(args []string) error {
 	// lvrename oldVolume newVolume
 	// lvchange --deltag lv.metal-stack.io/csi-lvm newVolume (was oldVolume)
 	// lvchange --addtag vg.metal-stack.io/csi-lvm-driver newVolume (was oldVolume)
-
-	stdout, stderr, err = migratorPod.Exec("umount /tmp/csi-lvm/"+oldVolumeName, nil)
-	if err != nil {
-		return fmt.Errorf("unable to umount volume %s: %s %s %s", oldVolumeName, err, stdout, stderr)
-	}
-	stdout, stderr, err = migratorPod.Exec("lvremove -y "+vgname+"/"+newVolumeName, nil)
-	if err != nil {
-		return fmt.Errorf("unable to remove dummy volume %s: %s %s %s", newVolumeName, err, stdout, stderr)
-	}
-	stdout, stderr, err = migratorPod.Exec("lvrename "+vgname+"/"+oldVolumeName+" "+vgname+"/"+newVolumeName, nil)
-	if err != nil {
-		return fmt.Errorf("unable to rename volume %s to %s: %s %s %s", oldVolumeName, newVolumeName, err, stdout, stderr)
-	}
-	stdout, stderr, err = migratorPod.Exec("lvchange --deltag lv.metal-stack.io/csi-lvm "+vgname+"/"+newVolumeName, nil)
-	if err != nil {
-		return fmt.Errorf("unable to remove tag lv.metal-stack.io/csi-lvm from %s: %s %s %s", newVolumeName, err, stdout, stderr)
-	}
-	stdout, stderr, err = migratorPod.Exec("lvchange --addtag vg.metal-stack.io/csi-lvm-driver  "+vgname+"/"+newVolumeName, nil)
-	if err != nil {
-		return fmt.Errorf("unable to add tag vg.metal-stack.io/csi-lvm-driver  from %s: %s %s %s", newVolumeName, err, stdout, stderr)
-	}
-
-	err = clientset.CoreV1().PersistentVolumes().Delete(context.TODO(), oldVolumeName, metav1.DeleteOptions{})
-	if err != nil {
-		return fmt.Errorf("unable remove old pventry %s: %s", oldVolumeName, err)
+	swap := &volumeSwap{
+		clientset:    clientset,
+		executor:     migratorPod,
+		namespace:    namespace,
+		node:         node,
+		vg:           vgname,
+		storageClass: newStorageClass,
+		size:         originalSize,
+		beforeRename: umount,
+		afterRename: func(newVolumeName string) error {
+			if move != nil && move.strategy == moveCopy {
+				// the copy is tagged by csi-driver-lvm already
+				stdout, stderr, err := migratorPod.Exec("lvchange --deltag "+lvm.TemporaryTag+" "+vgname+"/"+newVolumeName, nil)
+				if err != nil {
+					return fmt.Errorf("unable to remove tag %s from %s: %s %s %s", lvm.TemporaryTag, newVolumeName, err, stdout, stderr)
+				}
+				return nil
+			}
+			stdout, stderr, err := migratorPod.Exec("lvchange --deltag "+lvm.LegacyTag+" "+vgname+"/"+newVolumeName, nil)
+			if err != nil {
+				return fmt.Errorf("unable to remove tag %s from %s: %s %s %s", lvm.LegacyTag, newVolumeName, err, stdout, stderr)
+			}
+			stdout, stderr, err = migratorPod.Exec("lvchange --addtag "+lvm.DriverTag+" "+vgname+"/"+newVolumeName, nil)
+			if err != nil {
+				return fmt.Errorf("unable to add tag %s to %s: %s %s %s", lvm.DriverTag, newVolumeName, err, stdout, stderr)
+			}
+			return nil
+		},
 	}
-
-	// resize volumeclaim (volume itself already has the correct size), mount again to enforce resize
-	err = updateVolumeSize(clientset, namespace, pvcName, originalSize)
+	pvc, err = swap.run(pvc, lvName)
 	if err != nil {
 		return err
 	}
+	if move != nil && move.strategy == moveCopy {
+		stdout, stderr, err := migratorPod.Exec("lvremove -y "+oldLV.Path(), nil)
+		if err != nil {
+			return fmt.Errorf("unable to remove old volume %s: %s %s %s", oldLV.Path(), err, stdout, stderr)
+		}
+	}
 
 	fmt.Printf("Volume %s successfully migrated to csi-driver-lvm. You can start your pod again.\n", pvc.GetName())
 	fmt.Printf("Make sure to also change the storage class in your source files to the new storageClassName %s.\n", newStorageClass)
@@ -280,6 +239,10 @@ func setVolumeToRetain(clientset *kubernetes.Clientset, volumeName string) error
 			return fmt.Errorf("Failed to get latest volumes: %s", err)
 		}
 		result.Spec.PersistentVolumeReclaimPolicy = v1.PersistentVolumeReclaimRetain
+		if result.Labels == nil {
+			result.Labels = map[string]string{}
+		}
+		result.Labels[helper.RetainedLabel] = "true"
 		_, err = vols.Update(context.TODO(), result, metav1.UpdateOptions{})
 		return err
 	})
@@ -342,7 +305,11 @@ func startMounterPod(clientset *kubernetes.Clientset, node string, namespace str
 			},
 		},
 	}
-	err := helper.StartPodAndWait(clientset, namespace, tempMountPod)
+	err := helper.ApplyPodTemplate(tempMountPod, helper.MounterPod)
+	if err != nil {
+		return err
+	}
+	err = helper.StartPodAndWait(clientset, namespace, tempMountPod)
 	if err != nil {
 		return fmt.Errorf("could not create mount pod: %s", err)
 	}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+)
+
+// strategies for moving a logical volume to another volume group
+const (
+	moveAuto    = "auto"
+	moveVGSplit = "vgsplit"
+	moveCopy    = "copy"
+)
+
+// volumeMove is the plan for moving a logical volume to another volume group
+type volumeMove struct {
+	lv       *lvm.LogicalVolume
+	target   string
+	strategy string
+	// pvs are the physical volumes used only by the volume, they are moved
+	// along with it by vgsplit
+	pvs []string
+	// merge is set if the volume is the only one of its volume group, which
+	// is then merged as a whole into the target volume group
+	merge bool
+}
+
+func (m *volumeMove) String() string {
+	switch {
+	case m.strategy == moveCopy:
+		return fmt.Sprintf("copy %s to a new volume in %s", m.lv.Path(), m.target)
+	case m.merge:
+		return fmt.Sprintf("merge volume group %s with %v into %s", m.lv.VG, m.pvs, m.target)
+	}
+	return fmt.Sprintf("move %v of %s to %s with vgsplit", m.pvs, m.lv.Path(), m.target)
+}
+
+// planMove inspects the layout of the volume and chooses how to move it to
+// the target volume group. vgsplit is possible if the physical volumes of the
+// volume hold no other volumes and both volume groups use the same extent
+// size, otherwise the data is copied to a new volume.
+func planMove(e executor.Executor, lv *lvm.LogicalVolume, target string, strategy string) (*volumeMove, error) {
+	if strategy != moveAuto && strategy != moveVGSplit && strategy != moveCopy {
+		return nil, fmt.Errorf("unknown move strategy %q, must be auto, vgsplit or copy", strategy)
+	}
+	if lv.IsThin() {
+		return nil, fmt.Errorf("volume %s is a thin volume, it cannot be moved to volume group %s", lv.Path(), target)
+	}
+	source, err := lvm.GetVG(e, lv.VG)
+	if err != nil {
+		return nil, err
+	}
+	dest, err := lvm.GetVG(e, target)
+	if err != nil {
+		return nil, err
+	}
+	if source == nil || dest == nil {
+		return nil, fmt.Errorf("volume groups %s and %s must exist", lv.VG, target)
+	}
+
+	m := &volumeMove{lv: lv, target: target}
+	reason := ""
+	m.pvs, reason, err = exclusivePVs(e, lv)
+	if err != nil {
+		return nil, err
+	}
+	if reason == "" && source.ExtentSize != dest.ExtentSize {
+		reason = fmt.Sprintf("extent sizes of %s and %s differ", lv.VG, target)
+	}
+	if reason == "" {
+		m.merge = uint64(len(m.pvs)) == source.PVCount && source.LVCount == 1
+		if uint64(len(m.pvs)) == source.PVCount && !m.merge {
+			reason = fmt.Sprintf("all physical volumes of %s would be moved", lv.VG)
+		}
+	}
+
+	switch {
+	case reason == "" && strategy != moveCopy:
+		m.strategy = moveVGSplit
+		return m, nil
+	case strategy == moveVGSplit:
+		return nil, fmt.Errorf("vgsplit of %s is not possible: %s", lv.Path(), reason)
+	}
+
+	m.strategy = moveCopy
+	m.pvs = nil
+	m.merge = false
+	legs := uint64(1)
+	if lv.Type() == "mirror" {
+		legs = 2
+	}
+	needed := (lv.Size + dest.ExtentSize) * legs
+	if dest.Free < needed {
+		return nil, fmt.Errorf("volume group %s has %s free, %s needed to copy %s", target, formatBytes(dest.Free), formatBytes(needed), lv.Path())
+	}
+	return m, nil
+}
+
+// exclusivePVs returns the physical volumes holding the volume or why they are shared with other volumes
+func exclusivePVs(e executor.Executor, lv *lvm.LogicalVolume) ([]string, string, error) {
+	segments, err := lvm.ListSegments(e, lv.VG)
+	if err != nil {
+		return nil, "", err
+	}
+	used := map[string]bool{}
+	for _, s := range segments {
+		if s.LV == lv.Name {
+			used[s.PV] = true
+		}
+	}
+	var pvs []string
+	for pv := range used {
+		pvs = append(pvs, pv)
+	}
+	sort.Strings(pvs)
+	for _, s := range segments {
+		if used[s.PV] && s.LV != "" && s.LV != lv.Name {
+			return pvs, fmt.Sprintf("%s also holds %s", s.PV, s.LV), nil
+		}
+	}
+	return pvs, "", nil
+}
+
+// run moves the unused volume and returns its name in the target volume group
+func (m *volumeMove) run(e executor.Executor) (string, error) {
+	if m.strategy == moveCopy {
+		target, err := createTemporaryVolume(e, m.lv, m.target, m.lv.Name+"-move", m.lv.Size)
+		if err != nil {
+			return "", err
+		}
+		err = copyBlockDevice(e, m.lv, target)
+		if err != nil {
+			return "", err
+		}
+		return target.Name, nil
+	}
+
+	command := "vgsplit -n " + m.lv.Name + " " + m.lv.VG + " " + m.target
+	if m.merge {
+		command = "vgchange -an " + m.lv.VG + " && vgmerge " + m.target + " " + m.lv.VG
+	}
+	stdout, stderr, err := e.Exec("lvchange -an "+m.lv.Path()+" && "+command+" && lvchange -ay "+m.target+"/"+m.lv.Name, nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to move %s to %s: %v %s %s", m.lv.Path(), m.target, err, stdout, stderr)
+	}
+	return m.lv.Name, nil
+}
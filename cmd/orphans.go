@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/helper"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	orphansCmd = &cobra.Command{
+		Use:   "orphans",
+		Short: "find logical volumes without PersistentVolume and PersistentVolumes without logical volume",
+		Long: "find logical volumes created by csi-lvm or csi-driver-lvm which are not referenced by a PersistentVolume anymore " +
+			"and PersistentVolumes whose logical volume does not exist. With --purge the orphaned logical volumes are removed.",
+		PreRun: bindPFlags,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return orphans()
+		},
+	}
+)
+
+func init() {
+	orphansCmd.Flags().StringSlice("node", nil, "nodes to look for orphans on, default is all nodes with lvm volumes")
+	orphansCmd.Flags().Bool("purge", false, "remove the orphaned logical volumes")
+	orphansCmd.Flags().Duration("min-age", 0, "only purge logical volumes created longer ago than this")
+}
+
+func orphans() error {
+	clientset, config, namespace, err := newClientset()
+	if err != nil {
+		return err
+	}
+	pvs, err := lvmVolumes(clientset)
+	if err != nil {
+		return err
+	}
+	nodes, err := lvmNodes(clientset)
+	if err != nil {
+		return err
+	}
+	executors, err := startExecutors(clientset, config, nodes, namespace, "orphans")
+	if err != nil {
+		return err
+	}
+	defer executors.Destroy()
+
+	var orphanLVs []nodeLV
+	var danglingPVs []v1.PersistentVolume
+	for _, node := range nodes {
+		lvs, err := listNodeLVs(executors[node], node)
+		if err != nil {
+			return fmt.Errorf("unable to list logical volumes on node %s: %v", node, err)
+		}
+		existing := map[string]bool{}
+		for _, lv := range lvs {
+			existing[lv.Name] = true
+			if !orphanCandidate(&lv) {
+				continue
+			}
+			if findVolume(pvs, node, lv.Name) == nil {
+				orphanLVs = append(orphanLVs, nodeLV{node: node, lv: lv})
+			}
+		}
+		for _, pv := range pvs {
+			if helper.VolumeNode(&pv) == node && !existing[volumeLVName(&pv)] {
+				danglingPVs = append(danglingPVs, pv)
+			}
+		}
+	}
+
+	if len(orphanLVs) == 0 && len(danglingPVs) == 0 {
+		fmt.Println("No orphans found.")
+		return nil
+	}
+
+	minAge := viper.GetDuration("min-age")
+	var wasted uint64
+	var purgeable []nodeLV
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNODE\tNAME\tSIZE\tAGE\tPROBLEM")
+	for _, o := range orphanLVs {
+		problem := "no persistent volume"
+		switch {
+		case o.lv.IsOpen():
+			problem += ", in use"
+		case minAge > 0 && (o.lv.Time.IsZero() || time.Since(o.lv.Time) < minAge):
+			problem += ", younger than " + minAge.String()
+		default:
+			purgeable = append(purgeable, o)
+		}
+		wasted += o.lv.Size
+		fmt.Fprintf(w, "lv\t%s\t%s\t%s\t%s\t%s\n", o.node, o.lv.Path(), formatBytes(o.lv.Size), age(o.lv.Time), problem)
+	}
+	for _, pv := range danglingPVs {
+		size := pv.Spec.Capacity[v1.ResourceStorage]
+		fmt.Fprintf(w, "pv\t%s\t%s\t%s\t%s\t%s\n", helper.VolumeNode(&pv), pv.Name, size.String(), age(pv.CreationTimestamp.Time), "no logical volume")
+	}
+	w.Flush()
+	fmt.Printf("\n%d orphaned logical volumes wasting %s, %d persistent volumes without logical volume\n", len(orphanLVs), formatBytes(wasted), len(danglingPVs))
+
+	if !viper.GetBool("purge") {
+		return nil
+	}
+	if len(purgeable) == 0 {
+		fmt.Println("No logical volumes to purge.")
+		return nil
+	}
+
+	fmt.Printf("\nThe following %d logical volumes will be removed, their data is lost:\n", len(purgeable))
+	for _, o := range purgeable {
+		fmt.Printf("  %s on node %s\n", o.lv.Path(), o.node)
+	}
+	// removing volumes with data always needs the typed confirmation, --yes does not skip it
+	if err := helper.Prompt("Type \"purge\" to proceed: ", "purge"); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, o := range purgeable {
+		stdout, stderr, err := executors[o.node].Exec("lvremove -y "+o.lv.Path(), nil)
+		if err != nil {
+			fmt.Printf("unable to remove %s on node %s: %v %s %s\n", o.lv.Path(), o.node, err, stdout, stderr)
+			failed++
+			continue
+		}
+		fmt.Printf("%s on node %s removed\n", o.lv.Path(), o.node)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d logical volumes could not be removed", failed)
+	}
+	return nil
+}
+
+// orphanCandidate returns true for volumes created by the drivers. Temporary
+// volumes, e.g. the copy targets of shrink, convert and migrate, carry the
+// driver tag as well, they are handled by cleanup.
+func orphanCandidate(lv *lvm.LogicalVolume) bool {
+	if lv.HasTag(lvm.TemporaryTag) {
+		return false
+	}
+	return lv.HasTag(lvm.LegacyTag) || lv.HasTag(lvm.DriverTag)
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+)
+
+func TestOrphanCandidate(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		want bool
+	}{
+		{name: "csi-driver-lvm volume", tags: []string{lvm.DriverTag}, want: true},
+		{name: "csi-lvm volume", tags: []string{lvm.LegacyTag}, want: true},
+		{name: "temporary copy target", tags: []string{lvm.DriverTag, lvm.TemporaryTag}, want: false},
+		{name: "temporary volume", tags: []string{lvm.TemporaryTag}, want: false},
+		{name: "snapshot", tags: []string{lvm.SnapshotTag, lvm.ClaimTag("default", "my-db")}, want: false},
+		{name: "untagged volume", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lv := lvm.LogicalVolume{Name: "pvc-1a2b", VG: "csi-lvm", Tags: tt.tags}
+			if got := orphanCandidate(&lv); got != tt.want {
+				t.Errorf("orphanCandidate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+	"github.com/metal-stack/csilvmctl/cmd/internal/helper"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	raidCmd = &cobra.Command{
+		Use:   "raid",
+		Short: "repair and scrub raid1 mirrored volumes",
+	}
+	raidRepairCmd = &cobra.Command{
+		Use:   "repair <pvc>",
+		Short: "replace failed legs of a mirrored volume",
+		Long: "run lvconvert --repair on the raid volume of the PersistentVolumeClaim, e.g. after a failed disk was replaced, " +
+			"and wait until the mirror is in sync again. The claim must not be in use.",
+		PreRun: bindPFlags,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return raidRepair(args)
+		},
+	}
+	raidScrubCmd = &cobra.Command{
+		Use:   "scrub [pvc...]",
+		Short: "check or repair the consistency of mirrored volumes",
+		Long: "start a raid scrubbing with lvchange --syncaction on the raid volumes of the PersistentVolumeClaims or with --all on all raid volumes " +
+			"and wait for its completion. Claims in use are skipped for --action repair.",
+		PreRun: bindPFlags,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return raidScrub(args)
+		},
+	}
+)
+
+func init() {
+	raidRepairCmd.Flags().Duration("interval", 10*time.Second, "interval for reporting the sync progress")
+	raidRepairCmd.Flags().Duration("timeout", 24*time.Hour, "how long to wait for the mirror to get in sync, 0 waits forever")
+	raidScrubCmd.Flags().Bool("all", false, "scrub all raid volumes on all nodes")
+	raidScrubCmd.Flags().StringSlice("node", nil, "nodes to scrub with --all, default is all nodes with lvm volumes")
+	raidScrubCmd.Flags().String("action", "check", "scrubbing action, check only counts mismatches, repair corrects them")
+	raidScrubCmd.Flags().Duration("interval", 10*time.Second, "interval for reporting the scrubbing progress")
+	raidScrubCmd.Flags().Duration("timeout", 24*time.Hour, "how long to wait for the scrubbing of a volume, 0 waits forever")
+	raidCmd.AddCommand(raidRepairCmd)
+	raidCmd.AddCommand(raidScrubCmd)
+}
+
+// raidVolume is a raid logical volume with its claim
+type raidVolume struct {
+	node      string
+	lv        lvm.LogicalVolume
+	namespace string
+	claim     string
+}
+
+func (r raidVolume) String() string {
+	if r.claim == "" {
+		return r.lv.Path() + " on node " + r.node
+	}
+	return r.namespace + "/" + r.claim + " (" + r.lv.Path() + " on node " + r.node + ")"
+}
+
+func raidRepair(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("no pvc given")
+	}
+	pvcName := args[0]
+
+	clientset, config, namespace, err := newClientset()
+	if err != nil {
+		return err
+	}
+	_, pv, node, err := claimVolume(clientset, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+	err = checkClaimUnused(clientset, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+
+	return withExecutor(clientset, config, node, namespace, "raid", func(e executor.Executor) error {
+		lv, err := raidLV(e, volumeVG(pv, node), volumeLVName(pv))
+		if err != nil {
+			return err
+		}
+		r := raidVolume{node: node, lv: *lv, namespace: namespace, claim: pvcName}
+
+		fmt.Printf("Repairing %s, health: %s\n", r, healthOrOK(lv))
+		if !viper.GetBool("yes") {
+			if err := helper.Prompt("Do you want to proceed? (y/n) ", "y"); err != nil {
+				return err
+			}
+		}
+		stdout, stderr, err := e.Exec("lvconvert --repair -y "+lv.Path(), nil)
+		if err != nil {
+			return fmt.Errorf("unable to repair %s: %v %s %s", r, err, stdout, stderr)
+		}
+		err = waitForSyncStart(e, r)
+		if err != nil {
+			return err
+		}
+		lv, err = waitForSync(e, r)
+		if err != nil {
+			return err
+		}
+		status, problems := raidHealth(lv)
+		if status != healthOK {
+			return fmt.Errorf("%s still unhealthy after repair: %v", r, problems)
+		}
+		fmt.Printf("%s repaired\n", r)
+		return nil
+	})
+}
+
+func raidScrub(args []string) error {
+	action := viper.GetString("action")
+	if action != "check" && action != "repair" {
+		return fmt.Errorf("unknown action %q, must be check or repair", action)
+	}
+	all := viper.GetBool("all")
+	if len(args) == 0 && !all {
+		return fmt.Errorf("no pvc given, use --all to scrub all raid volumes")
+	}
+
+	clientset, config, namespace, err := newClientset()
+	if err != nil {
+		return err
+	}
+	pvs, err := lvmVolumes(clientset)
+	if err != nil {
+		return err
+	}
+
+	// raid volume names to scrub per node, empty for all
+	targets := map[string][]string{}
+	if all {
+		nodes, err := lvmNodes(clientset)
+		if err != nil {
+			return err
+		}
+		for _, node := range nodes {
+			targets[node] = nil
+		}
+	} else {
+		for _, pvcName := range args {
+			_, pv, node, err := claimVolume(clientset, namespace, pvcName)
+			if err != nil {
+				return err
+			}
+			targets[node] = append(targets[node], volumeLVName(pv))
+		}
+	}
+
+	failed := 0
+	for node, names := range targets {
+		err := withExecutor(clientset, config, node, namespace, "raid", func(e executor.Executor) error {
+			volumes, err := raidVolumes(e, node, names, pvs)
+			if err != nil {
+				return err
+			}
+			for _, r := range volumes {
+				if action == "repair" && r.claim != "" {
+					if err := checkClaimUnused(clientset, r.namespace, r.claim); err != nil {
+						fmt.Printf("%s: skipped, %v\n", r, err)
+						continue
+					}
+				}
+				if err := scrubVolume(e, r, action); err != nil {
+					fmt.Printf("%s: %v\n", r, err)
+					failed++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("node %s: %v\n", node, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("scrubbing failed for %d volumes or nodes", failed)
+	}
+	return nil
+}
+
+// raidVolumes returns the raid volumes with the given names on the node, all if names is empty
+func raidVolumes(e executor.Executor, node string, names []string, pvs []v1.PersistentVolume) ([]raidVolume, error) {
+	lvs, err := listNodeLVs(e, node)
+	if err != nil {
+		return nil, err
+	}
+	var result []raidVolume
+	for _, name := range names {
+		if !containsLV(lvs, name) {
+			return nil, fmt.Errorf("logical volume %s not found", name)
+		}
+	}
+	for _, lv := range lvs {
+		if len(names) > 0 && !contains(names, lv.Name) {
+			continue
+		}
+		if !lv.IsRaid() {
+			if len(names) > 0 {
+				return nil, fmt.Errorf("logical volume %s is no raid volume", lv.Path())
+			}
+			continue
+		}
+		r := raidVolume{node: node, lv: lv}
+		if pv := findVolume(pvs, node, lv.Name); pv != nil && pv.Spec.ClaimRef != nil {
+			r.namespace, r.claim = pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name
+		}
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+func scrubVolume(e executor.Executor, r raidVolume, action string) error {
+	if r.lv.SyncAction != "" && r.lv.SyncAction != "idle" {
+		return fmt.Errorf("sync action %s already running", r.lv.SyncAction)
+	}
+	fmt.Printf("%s: starting %s\n", r, action)
+	stdout, stderr, err := e.Exec("lvchange --syncaction "+action+" "+r.lv.Path(), nil)
+	if err != nil {
+		return fmt.Errorf("unable to start %s: %v %s %s", action, err, stdout, stderr)
+	}
+	err = waitForSyncStart(e, r)
+	if err != nil {
+		return err
+	}
+	lv, err := waitForSync(e, r)
+	if err != nil {
+		return err
+	}
+	status, problems := raidHealth(lv)
+	fmt.Printf("%s: %s finished with %d mismatches, status %s %v\n", r, action, lv.MismatchCount, healthNames[status], problems)
+	return nil
+}
+
+// raidLV returns the raid logical volume with the given name
+func raidLV(e executor.Executor, vg string, name string) (*lvm.LogicalVolume, error) {
+	lv, err := lvm.GetLV(e, vg, name)
+	if err != nil {
+		return nil, err
+	}
+	if lv == nil {
+		return nil, fmt.Errorf("logical volume %s not found", name)
+	}
+	if !lv.IsRaid() {
+		return nil, fmt.Errorf("logical volume %s is no raid volume", lv.Path())
+	}
+	return lv, nil
+}
+
+// syncStartTimeout is how long a started sync action may take to show up
+const syncStartTimeout = 30 * time.Second
+
+// waitForSyncStart waits until a sync action started on the raid volume shows
+// up, right after starting it the kernel may still report the previous idle
+// and in sync state. r holds the state before the action was started.
+func waitForSyncStart(e executor.Executor, r raidVolume) error {
+	start := time.Now()
+	for {
+		lv, err := raidLV(e, r.lv.VG, r.lv.Name)
+		if err != nil {
+			return err
+		}
+		if (lv.SyncAction != "" && lv.SyncAction != "idle") || lv.SyncPercent < 100 || lv.MismatchCount != r.lv.MismatchCount {
+			return nil
+		}
+		if time.Since(start) > syncStartTimeout {
+			// small volumes may be done before the first look
+			fmt.Printf("%s: no sync action seen within %s, it may have finished already\n", r, syncStartTimeout)
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// waitForSync reports the sync progress of the raid volume until it is in sync
+// and idle or the timeout is exceeded
+func waitForSync(e executor.Executor, r raidVolume) (*lvm.LogicalVolume, error) {
+	interval := viper.GetDuration("interval")
+	timeout := viper.GetDuration("timeout")
+	start := time.Now()
+	for {
+		lv, err := raidLV(e, r.lv.VG, r.lv.Name)
+		if err != nil {
+			return nil, err
+		}
+		if lv.SyncPercent >= 100 && (lv.SyncAction == "" || lv.SyncAction == "idle") {
+			return lv, nil
+		}
+		// a mirror with missing legs will not get in sync
+		if lv.HealthStatus == "partial" || lv.HealthStatus == "failed" {
+			return lv, nil
+		}
+		if timeout > 0 && time.Since(start) > timeout {
+			return nil, fmt.Errorf("%s not in sync after %s, %s at %.2f%%", r, timeout, lv.SyncAction, lv.SyncPercent)
+		}
+		fmt.Printf("%s: %s %.2f%%\n", r, lv.SyncAction, lv.SyncPercent)
+		time.Sleep(interval)
+	}
+}
+
+func healthOrOK(lv *lvm.LogicalVolume) string {
+	if lv.HealthStatus == "" {
+		return "ok"
+	}
+	return lv.HealthStatus
+}
+
+func containsLV(lvs []lvm.LogicalVolume, name string) bool {
+	for _, lv := range lvs {
+		if lv.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+	"github.com/metal-stack/csilvmctl/cmd/internal/helper"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	resizeCmd = &cobra.Command{
+		Use:   "resize <pvc> <size>",
+		Short: "expand a PersistentVolumeClaim without csi volume expansion",
+		Long: "extend the logical volume of an unused PersistentVolumeClaim and grow its ext4 or xfs filesystem, " +
+			"then update the capacity of the PersistentVolume and of the claim. The request of the claim is only raised if its storage class allows volume expansion.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return resizeVolume(args)
+		},
+	}
+)
+
+func resizeVolume(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("no pvc and size given")
+	}
+	pvcName := args[0]
+	size, err := resource.ParseQuantity(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid size %s: %v", args[1], err)
+	}
+
+	clientset, config, namespace, err := newClientset()
+	if err != nil {
+		return err
+	}
+	pvc, pv, node, err := claimVolume(clientset, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+	err = checkClaimUnused(clientset, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+
+	e, err := startExecutor(clientset, config, node, namespace, "resize")
+	if err != nil {
+		return err
+	}
+	defer e.Destroy()
+
+	vgname := volumeVG(pv, node)
+	lv, err := lvm.GetLV(e, vgname, volumeLVName(pv))
+	if err != nil {
+		return err
+	}
+	if lv == nil {
+		return fmt.Errorf("logical volume %s not found in volume group %s on node %s", volumeLVName(pv), vgname, node)
+	}
+	newSize := uint64(size.Value())
+	if newSize <= lv.Size {
+		return fmt.Errorf("volume %s already has %s, shrink it instead", lv.Path(), formatBytes(lv.Size))
+	}
+
+	if lv.IsThin() {
+		// thin volumes allocate from their pool when written to
+		warning, err := thinPoolWarning(e, lv)
+		if err != nil {
+			return err
+		}
+		if warning != "" {
+			fmt.Printf("warning: %s\n", warning)
+		}
+	} else {
+		err = checkExtendSpace(e, lv, newSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the api server rejects larger requests of claims whose storage class does not allow expansion
+	expandable, err := claimExpandable(clientset, pvc)
+	if err != nil {
+		return err
+	}
+
+	fstype := ""
+	if pvc.Spec.VolumeMode == nil || *pvc.Spec.VolumeMode == v1.PersistentVolumeFilesystem {
+		fstype, err = filesystemType(e, lv.DevicePath())
+		if err != nil {
+			return err
+		}
+		if fstype == "" {
+			return fmt.Errorf("no filesystem found on %s", lv.DevicePath())
+		}
+	}
+
+	fmt.Printf("Resizing volume %s (%s, %s) on node %s from %s to %s\n", pvcName, lv.Path(), fstype, node, formatBytes(lv.Size), size.String())
+	if !expandable {
+		fmt.Println("The storage class does not allow volume expansion, only the capacity of the volume and the claim is updated, its request is kept.")
+	}
+	if !viper.GetBool("yes") {
+		if err := helper.Prompt("Do you want to proceed? (y/n) ", "y"); err != nil {
+			return err
+		}
+	}
+
+	stdout, stderr, err := e.Exec("lvextend -L "+strconv.FormatUint(newSize, 10)+"b "+lv.Path(), nil)
+	if err != nil {
+		return fmt.Errorf("unable to extend %s: %v %s %s", lv.Path(), err, stdout, stderr)
+	}
+	if fstype != "" {
+		err = growFilesystem(e, lv.DevicePath(), fstype)
+		if err != nil {
+			return err
+		}
+	}
+
+	// lvm rounds up to whole extents
+	lv, err = lvm.GetLV(e, vgname, lv.Name)
+	if err != nil {
+		return err
+	}
+	capacity := resource.NewQuantity(int64(lv.Size), resource.BinarySI).String()
+	err = updateVolumeCapacity(clientset, pv.Name, capacity)
+	if err != nil {
+		return err
+	}
+	err = updateClaimCapacity(clientset, namespace, pvcName, capacity, expandable)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Volume %s successfully resized to %s. You can start your pod again.\n", pvcName, capacity)
+	return nil
+}
+
+// checkExtendSpace makes sure the volume group has enough free space to extend the volume to the new size
+func checkExtendSpace(e executor.Executor, lv *lvm.LogicalVolume, newSize uint64) error {
+	vg, err := lvm.GetVG(e, lv.VG)
+	if err != nil {
+		return err
+	}
+	if vg == nil {
+		return fmt.Errorf("volume group %s not found", lv.VG)
+	}
+	legs := uint64(1)
+	if lv.Type() == "mirror" {
+		legs = 2
+	}
+	needed := (newSize - lv.Size + vg.ExtentSize) * legs
+	if vg.Free < needed {
+		return fmt.Errorf("volume group %s has %s free, %s needed", lv.VG, formatBytes(vg.Free), formatBytes(needed))
+	}
+	return nil
+}
+
+// updateVolumeCapacity sets the capacity of the persistent volume
+func updateVolumeCapacity(clientset *kubernetes.Clientset, volumeName string, size string) error {
+	vols := clientset.CoreV1().PersistentVolumes()
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		result, err := vols.Get(context.TODO(), volumeName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("Failed to get latest volumes: %s", err)
+		}
+		result.Spec.Capacity[v1.ResourceStorage] = resource.MustParse(size)
+		_, err = vols.Update(context.TODO(), result, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// claimExpandable returns true if the storage class of the claim allows volume expansion
+func claimExpandable(clientset *kubernetes.Clientset, pvc *v1.PersistentVolumeClaim) (bool, error) {
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return false, nil
+	}
+	sc, err := clientset.StorageV1().StorageClasses().Get(context.TODO(), *pvc.Spec.StorageClassName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to get storage class %s: %v", *pvc.Spec.StorageClassName, err)
+	}
+	return sc.AllowVolumeExpansion != nil && *sc.AllowVolumeExpansion, nil
+}
+
+// updateClaimCapacity sets the reported capacity and, if the storage class allows
+// expansion, the request of the claim. The capacity of its volume must be updated
+// before to not trigger a csi expansion.
+func updateClaimCapacity(clientset *kubernetes.Clientset, namespace string, pvcName string, size string, expandable bool) error {
+	pvcs := clientset.CoreV1().PersistentVolumeClaims(namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		result, err := pvcs.Get(context.TODO(), pvcName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("Failed to get latest volumeclaims: %s", err)
+		}
+		if result.Status.Capacity == nil {
+			result.Status.Capacity = v1.ResourceList{}
+		}
+		result.Status.Capacity[v1.ResourceStorage] = resource.MustParse(size)
+		_, err = pvcs.UpdateStatus(context.TODO(), result, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to update capacity of pvc %s: %v", pvcName, err)
+	}
+	if !expandable {
+		return nil
+	}
+	err = updateVolumeSize(clientset, namespace, pvcName, size)
+	if err != nil {
+		return fmt.Errorf("unable to update request of pvc %s: %v", pvcName, err)
+	}
+	return nil
+}
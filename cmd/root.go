@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -37,6 +38,16 @@ var (
 	}
 )
 
+// exitError makes the program exit with the given code, e.g. for monitoring
+type exitError struct {
+	code int
+	msg  string
+}
+
+func (e *exitError) Error() string {
+	return e.msg
+}
+
 // Execute is the entrypoint of the cient-go application
 func Execute() {
 	err := rootCmd.Execute()
@@ -45,6 +56,10 @@ func Execute() {
 			st := errors.WithStack(err)
 			fmt.Printf("%+v", st)
 		}
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
 		os.Exit(1)
 	}
 }
@@ -59,16 +74,42 @@ func init() {
 		}
 	}
 
+	rootCmd.PersistentFlags().String("config", "", "config file, default is ~/.csilvmctl/config.yaml")
 	rootCmd.PersistentFlags().String("kubeconfig", kubeconfig, "Path to the kube-config to use for authentication and authorization. Is updated by login.")
 	rootCmd.PersistentFlags().StringP("namespace", "n", "", "namespace")
 	rootCmd.PersistentFlags().String("provisioner", "lvm.csi.metal-stack.io", "csi-driver-lvm storage provisioner")
-	rootCmd.PersistentFlags().String("vgname", "csi-lvm", "name of the lvm volume group")
+	rootCmd.PersistentFlags().String("vgname", "", "volume group of csi-driver-lvm, default is resolved per node from the config file, node annotations or lvm tags, falling back to csi-lvm")
+	rootCmd.PersistentFlags().String("legacy-vgname", "", "volume group of csi-lvm, default is resolved per node like vgname, falling back to the csi-driver-lvm volume group")
 	rootCmd.PersistentFlags().String("migrator-pod-image", "metalstack/lvmplugin:v0.3.5", "image used for the migratior pod")
+	rootCmd.PersistentFlags().String("executor", executorPlugin, "how to run lvm commands on a node: plugin (reuse the csi-driver-lvm plugin pod, fall back to a migrator pod), pod (always start a migrator pod), local (run on this host) or ssh (connect to the node via ssh)")
+	rootCmd.PersistentFlags().String("ssh-user", "root", "user for the ssh executor")
+	rootCmd.PersistentFlags().String("ssh-port", "22", "port for the ssh executor, unless specified in ssh-hosts of the config file")
+	rootCmd.PersistentFlags().String("ssh-identity", "", "private key for the ssh executor, the ssh agent is used if empty")
+	rootCmd.PersistentFlags().String("ssh-known-hosts", "", "known_hosts file to verify nodes with, default is ~/.ssh/known_hosts")
+	rootCmd.PersistentFlags().Duration("ssh-timeout", 10*time.Second, "timeout for establishing ssh connections")
+	rootCmd.PersistentFlags().Float64("thin-pool-warn-percent", 80, "warn when data or metadata of a thin pool are used more than this")
+	rootCmd.PersistentFlags().String("pod-template", "", "yaml file with strategic merge patches for the migrator and mounter pods")
 	rootCmd.PersistentFlags().BoolP("yes", "y", false, "answer yes to all questions")
 
 	//rootCmd.AddCommand(completionCmd)
 	//rootCmd.AddCommand(zshCompletionCmd)
 	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(capacityCmd)
+	rootCmd.AddCommand(cleanupCmd)
+	rootCmd.AddCommand(cloneCmd)
+	rootCmd.AddCommand(convertCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(fsckCmd)
+	rootCmd.AddCommand(healthCmd)
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(lvCmd)
+	rootCmd.AddCommand(nodeCmd)
+	rootCmd.AddCommand(orphansCmd)
+	rootCmd.AddCommand(raidCmd)
+	rootCmd.AddCommand(resizeCmd)
+	rootCmd.AddCommand(shrinkCmd)
+	rootCmd.AddCommand(snapshotCmd)
+	rootCmd.AddCommand(vgCmd)
 
 	err := viper.BindPFlags(rootCmd.PersistentFlags())
 	if err != nil {
@@ -80,6 +121,19 @@ func initConfig() {
 	viper.SetEnvPrefix(strings.ToUpper(programName))
 	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	viper.AutomaticEnv()
+
+	if cfg := viper.GetString("config"); cfg != "" {
+		viper.SetConfigFile(cfg)
+	} else {
+		viper.SetConfigName("config")
+		viper.AddConfigPath(fmt.Sprintf("/etc/%s", programName))
+		viper.AddConfigPath(fmt.Sprintf("$HOME/.%s", programName))
+	}
+	if err := viper.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			log.Fatalf("config file %s invalid: %v", viper.ConfigFileUsed(), err)
+		}
+	}
 }
 
 func homeDir() string {
@@ -0,0 +1,241 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+	"github.com/metal-stack/csilvmctl/cmd/internal/helper"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	shrinkCmd = &cobra.Command{
+		Use:   "shrink <pvc> <size>",
+		Short: "shrink an unused PersistentVolumeClaim",
+		Long: "shrink the ext4 filesystem and the logical volume of an unused PersistentVolumeClaim and rebind the claim with the new size. " +
+			"xfs filesystems cannot be shrunk, with --copy the data is copied to a new smaller volume instead.",
+		PreRun: bindPFlags,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return shrinkVolume(args)
+		},
+	}
+)
+
+func init() {
+	shrinkCmd.Flags().Bool("copy", false, "copy the data to a new smaller volume instead of shrinking the filesystem in place")
+}
+
+func shrinkVolume(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("no pvc and size given")
+	}
+	pvcName := args[0]
+	size, err := resource.ParseQuantity(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid size %s: %v", args[1], err)
+	}
+	newSize := uint64(size.Value())
+
+	clientset, config, namespace, err := newClientset()
+	if err != nil {
+		return err
+	}
+	pvc, pv, node, err := claimVolume(clientset, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+	if pvc.Spec.VolumeMode != nil && *pvc.Spec.VolumeMode == v1.PersistentVolumeBlock {
+		return fmt.Errorf("pvc %s is a block volume, its content is unknown", pvcName)
+	}
+	if pvc.Spec.StorageClassName == nil {
+		return fmt.Errorf("pvc %s has no storage class", pvcName)
+	}
+	err = checkClaimUnused(clientset, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+
+	e, err := startExecutor(clientset, config, node, namespace, "shrink")
+	if err != nil {
+		return err
+	}
+	defer e.Destroy()
+
+	vgname := volumeVG(pv, node)
+	lv, err := lvm.GetLV(e, vgname, volumeLVName(pv))
+	if err != nil {
+		return err
+	}
+	if lv == nil {
+		return fmt.Errorf("logical volume %s not found in volume group %s on node %s", volumeLVName(pv), vgname, node)
+	}
+	if newSize >= lv.Size {
+		return fmt.Errorf("volume %s has %s, resize it instead", lv.Path(), formatBytes(lv.Size))
+	}
+
+	fstype, err := filesystemType(e, lv.DevicePath())
+	if err != nil {
+		return err
+	}
+	copyData := viper.GetBool("copy")
+	switch fstype {
+	case fsExt4:
+	case fsXFS:
+		if !copyData {
+			return fmt.Errorf("xfs filesystems cannot be shrunk, use --copy to copy the data of %s to a new volume of %s instead", pvcName, size.String())
+		}
+	default:
+		return fmt.Errorf("shrinking %q filesystems is not supported", fstype)
+	}
+
+	err = checkFilesystem(e, lv.DevicePath(), fstype)
+	if err != nil {
+		return err
+	}
+	used, err := filesystemUsed(e, lv.DevicePath())
+	if err != nil {
+		return err
+	}
+	// leave room for the filesystem metadata
+	if used+used/10 > newSize {
+		return fmt.Errorf("%s of %s are used, which does not fit into %s", formatBytes(used), lv.Path(), size.String())
+	}
+
+	mode := "in place"
+	if copyData {
+		mode = "by copying its data"
+	}
+	fmt.Printf("Shrinking volume %s (%s, %s, %s used) on node %s from %s to %s %s\n", pvcName, lv.Path(), fstype, formatBytes(used), node, formatBytes(lv.Size), size.String(), mode)
+	if !viper.GetBool("yes") {
+		if err := helper.Prompt("Do you want to proceed? (y/n) ", "y"); err != nil {
+			return err
+		}
+	}
+	fmt.Println("Please wait ...")
+
+	swap := &volumeSwap{
+		clientset:    clientset,
+		executor:     e,
+		namespace:    namespace,
+		node:         node,
+		vg:           vgname,
+		storageClass: *pvc.Spec.StorageClassName,
+	}
+	var target *lvm.LogicalVolume
+	if copyData {
+		target, err = copyToSmallerVolume(e, lv, fstype, newSize)
+		if err != nil {
+			return err
+		}
+		swap.afterRename = func(lvName string) error {
+			stdout, stderr, err := e.Exec("lvchange --deltag "+lvm.TemporaryTag+" "+vgname+"/"+lvName, nil)
+			if err != nil {
+				return fmt.Errorf("unable to remove tag %s from %s: %s %s %s", lvm.TemporaryTag, lvName, err, stdout, stderr)
+			}
+			return nil
+		}
+	} else {
+		target, err = shrinkInPlace(e, lv, newSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	swap.size = resource.NewQuantity(int64(target.Size), resource.BinarySI).String()
+	_, err = swap.run(pvc, target.Name)
+	if err != nil {
+		return err
+	}
+
+	if copyData {
+		stdout, stderr, err := e.Exec("lvremove -y "+lv.Path(), nil)
+		if err != nil {
+			return fmt.Errorf("unable to remove old volume %s: %s %s %s", lv.Path(), err, stdout, stderr)
+		}
+	}
+
+	fmt.Printf("Volume %s successfully shrunk to %s. You can start your pod again.\n", pvcName, swap.size)
+	return nil
+}
+
+// shrinkInPlace shrinks the ext4 filesystem and afterwards the logical volume
+func shrinkInPlace(e executor.Executor, lv *lvm.LogicalVolume, size uint64) (*lvm.LogicalVolume, error) {
+	// lvreduce rounds up to whole extents, so the volume stays larger than the filesystem
+	stdout, stderr, err := e.Exec("e2fsck -f -p "+lv.DevicePath()+" && resize2fs "+lv.DevicePath()+" "+strconv.FormatUint(size/1024, 10)+"K", nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to shrink filesystem on %s: %v %s %s", lv.DevicePath(), err, stdout, stderr)
+	}
+	stdout, stderr, err = e.Exec("lvreduce -f -L "+strconv.FormatUint(size, 10)+"b "+lv.Path(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reduce %s: %v %s %s", lv.Path(), err, stdout, stderr)
+	}
+	return lvm.GetLV(e, lv.VG, lv.Name)
+}
+
+// copyToSmallerVolume creates a temporary logical volume of the same type with
+// a new filesystem and copies the files of the volume to it
+func copyToSmallerVolume(e executor.Executor, lv *lvm.LogicalVolume, fstype string, size uint64) (*lvm.LogicalVolume, error) {
+	target, err := createTemporaryVolume(e, lv, lv.VG, lv.Name+"-shrink", size)
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, stderr, err := e.Exec("mkfs -t "+fstype+" "+target.DevicePath(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create filesystem on %s: %v %s %s", target.DevicePath(), err, stdout, stderr)
+	}
+	err = withMountedFilesystem(e, lv.DevicePath(), "ro", func(src string) error {
+		return withMountedFilesystem(e, target.DevicePath(), "", func(dst string) error {
+			stdout, stderr, err := e.Exec("tar -C "+src+" -cf - . | tar -C "+dst+" -xpf -", nil)
+			if err != nil {
+				return fmt.Errorf("unable to copy data from %s to %s: %v %s %s", lv.Path(), target.Path(), err, stdout, stderr)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// createTemporaryVolume creates a csi-driver-lvm logical volume of the same type
+// as the given one in the volume group, tagged for removal by cleanup
+func createTemporaryVolume(e executor.Executor, lv *lvm.LogicalVolume, vg string, name string, size uint64) (*lvm.LogicalVolume, error) {
+	return createTypedVolume(e, vg, name, size, lv.Type(), len(lv.Devices), lv.PoolLV)
+}
+
+// createTypedVolume creates a csi-driver-lvm logical volume of the given type,
+// striped volumes get the given number of stripes and thin volumes are created
+// in the given pool. It is tagged for removal by cleanup.
+func createTypedVolume(e executor.Executor, vg string, name string, size uint64, lvType string, stripes int, pool string) (*lvm.LogicalVolume, error) {
+	layout := "-L " + strconv.FormatUint(size, 10) + "b "
+	switch lvType {
+	case "mirror":
+		layout += "--type raid1 -m 1 "
+	case "striped":
+		layout += "-i " + strconv.Itoa(stripes) + " "
+	case "thin":
+		layout = "-V " + strconv.FormatUint(size, 10) + "b --thinpool " + pool + " "
+	}
+	stdout, stderr, err := e.Exec("lvcreate -y -n "+name+" "+layout+
+		"--addtag "+lvm.DriverTag+" --addtag "+lvm.TemporaryTag+" "+vg, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create volume %s: %v %s %s", name, err, stdout, stderr)
+	}
+	target, err := lvm.GetLV(e, vg, name)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		return nil, fmt.Errorf("created volume %s not found", name)
+	}
+	return target, nil
+}
@@ -0,0 +1,333 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+	"github.com/metal-stack/csilvmctl/cmd/internal/helper"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	snapshotCmd = &cobra.Command{
+		Use:   "snapshot",
+		Short: "manage lvm snapshots of PersistentVolumeClaims",
+		Long: "create point-in-time copies of the logical volumes of PersistentVolumeClaims with lvm snapshots. " +
+			"Snapshots use copy-on-write space and become invalid when it runs full.",
+	}
+	snapshotCreateCmd = &cobra.Command{
+		Use:    "create <pvc>",
+		Short:  "create a snapshot of a PersistentVolumeClaim",
+		PreRun: bindPFlags,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return createSnapshot(args)
+		},
+	}
+	snapshotListCmd = &cobra.Command{
+		Use:    "list [pvc]",
+		Short:  "list the snapshots of a PersistentVolumeClaim or of all claims",
+		PreRun: bindPFlags,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listSnapshots(args)
+		},
+	}
+	snapshotDeleteCmd = &cobra.Command{
+		Use:   "delete <pvc> <snapshot>",
+		Short: "delete a snapshot of a PersistentVolumeClaim",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return deleteSnapshot(args)
+		},
+	}
+	snapshotRestoreCmd = &cobra.Command{
+		Use:   "restore <pvc> <snapshot>",
+		Short: "restore an unused PersistentVolumeClaim to a snapshot",
+		Long: "merge the snapshot into the logical volume of the unused PersistentVolumeClaim with lvconvert --merge, " +
+			"all changes since the snapshot was taken are lost and the snapshot is removed",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return restoreSnapshot(args)
+		},
+	}
+)
+
+func init() {
+	snapshotCreateCmd.Flags().String("name", "", "name of the snapshot, default is <lv>-snap-<timestamp>")
+	snapshotCreateCmd.Flags().String("size", "", "copy-on-write space of the snapshot, default is 20% of the volume")
+	snapshotListCmd.Flags().StringSlice("node", nil, "nodes to list the snapshots of, default is all nodes with lvm volumes")
+	snapshotListCmd.Flags().Float64("warn-percent", 80, "warn about snapshots whose copy-on-write space is used more than this")
+	snapshotCmd.AddCommand(snapshotCreateCmd, snapshotListCmd, snapshotDeleteCmd, snapshotRestoreCmd)
+}
+
+// snapshotTimeFormat is appended to the volume name when no snapshot name is given
+const snapshotTimeFormat = "20060102150405"
+
+func createSnapshot(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("no pvc given")
+	}
+	pvcName := args[0]
+
+	clientset, config, namespace, err := newClientset()
+	if err != nil {
+		return err
+	}
+	_, pv, node, err := claimVolume(clientset, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+
+	return withExecutor(clientset, config, node, namespace, "snapshot", func(e executor.Executor) error {
+		vgname := volumeVG(pv, node)
+		lv, err := lvm.GetLV(e, vgname, volumeLVName(pv))
+		if err != nil {
+			return err
+		}
+		if lv == nil {
+			return fmt.Errorf("logical volume %s not found in volume group %s on node %s", volumeLVName(pv), vgname, node)
+		}
+		sizeOption, err := snapshotSizeOption(lv, viper.GetString("size"))
+		if err != nil {
+			return err
+		}
+		name := viper.GetString("name")
+		if name == "" {
+			name = lv.Name + "-snap-" + time.Now().Format(snapshotTimeFormat)
+		}
+		stdout, stderr, err := e.Exec("lvcreate -y -s -n "+name+" "+sizeOption+
+			" --addtag "+lvm.SnapshotTag+" --addtag "+lvm.ClaimTag(namespace, pvcName)+" "+lv.Path(), nil)
+		if err != nil {
+			return fmt.Errorf("unable to create snapshot %s of %s: %v %s %s", name, lv.Path(), err, stdout, stderr)
+		}
+		snap, err := lvm.GetLV(e, vgname, name)
+		if err != nil {
+			return err
+		}
+		if snap == nil {
+			return fmt.Errorf("created snapshot %s not found", name)
+		}
+		if snap.IsThin() {
+			fmt.Printf("Thin snapshot %s of volume %s (%s) created on node %s in pool %s\n", name, pvcName, lv.Path(), node, snap.PoolLV)
+			warning, err := thinPoolWarning(e, snap)
+			if err != nil {
+				return err
+			}
+			if warning != "" {
+				fmt.Printf("warning: %s\n", warning)
+			}
+			return nil
+		}
+		fmt.Printf("Snapshot %s of volume %s (%s) created on node %s with %s copy-on-write space\n", name, pvcName, lv.Path(), node, formatBytes(snap.Size))
+		return nil
+	})
+}
+
+func listSnapshots(args []string) error {
+	clientset, config, namespace, err := newClientset()
+	if err != nil {
+		return err
+	}
+	var nodes []string
+	claimTag := ""
+	if len(args) > 0 {
+		_, _, node, err := claimVolume(clientset, namespace, args[0])
+		if err != nil {
+			return err
+		}
+		nodes = []string{node}
+		claimTag = lvm.ClaimTag(namespace, args[0])
+	} else {
+		nodes, err = lvmNodes(clientset)
+		if err != nil {
+			return err
+		}
+	}
+
+	var snapshots []nodeLV
+	for _, node := range nodes {
+		err := withExecutor(clientset, config, node, namespace, "snapshot", func(e executor.Executor) error {
+			lvs, err := listNodeLVs(e, node)
+			if err != nil {
+				return fmt.Errorf("unable to list logical volumes on node %s: %v", node, err)
+			}
+			for _, lv := range lvs {
+				if !lv.IsSnapshot() || !lv.HasTag(lvm.SnapshotTag) {
+					continue
+				}
+				if claimTag != "" && !lv.HasTag(claimTag) {
+					continue
+				}
+				snapshots = append(snapshots, nodeLV{node: node, lv: lv})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	warnPercent := viper.GetFloat64("warn-percent")
+	var warnings []string
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tSNAPSHOT\tORIGIN\tCLAIM\tSIZE\tUSED\tAGE")
+	for _, s := range snapshots {
+		used := "-"
+		switch {
+		case s.lv.IsThin():
+			// thin snapshots only run full with their pool
+			used = "pool " + s.lv.PoolLV
+		case s.lv.IsInvalid():
+			used = "invalid"
+			warnings = append(warnings, fmt.Sprintf("snapshot %s on node %s ran out of space and is invalid, delete it", s.lv.Path(), s.node))
+		case s.lv.DataPercent >= 0:
+			used = fmt.Sprintf("%.2f%%", s.lv.DataPercent)
+			if s.lv.DataPercent >= warnPercent {
+				warnings = append(warnings, fmt.Sprintf("snapshot %s on node %s is %.2f%% full", s.lv.Path(), s.node, s.lv.DataPercent))
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.node, s.lv.Name, s.lv.Origin, snapshotClaim(&s.lv), formatBytes(s.lv.Size), used, age(s.lv.Time))
+	}
+	w.Flush()
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	return nil
+}
+
+// snapshotSizeOption returns the lvcreate option for the copy-on-write space of
+// a snapshot, which is 20% of the volume if no size is given. Snapshots of thin
+// volumes without size are thin snapshots in the same pool, they are activated
+// although lvm skips them by default.
+func snapshotSizeOption(lv *lvm.LogicalVolume, size string) (string, error) {
+	if size == "" && lv.IsThin() {
+		return "-kn", nil
+	}
+	if size == "" {
+		return "-l 20%ORIGIN", nil
+	}
+	q, err := resource.ParseQuantity(size)
+	if err != nil {
+		return "", fmt.Errorf("invalid size %s: %v", size, err)
+	}
+	return "-L " + strconv.FormatInt(q.Value(), 10) + "b", nil
+}
+
+// snapshotClaim returns namespace/name of the claim the snapshot was taken of
+func snapshotClaim(lv *lvm.LogicalVolume) string {
+	for _, t := range lv.Tags {
+		if strings.HasPrefix(t, lvm.ClaimTagPrefix) {
+			return strings.TrimPrefix(t, lvm.ClaimTagPrefix)
+		}
+	}
+	return "-"
+}
+
+func deleteSnapshot(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("no pvc and snapshot given")
+	}
+	pvcName, name := args[0], args[1]
+	clientset, config, namespace, err := newClientset()
+	if err != nil {
+		return err
+	}
+	_, pv, node, err := claimVolume(clientset, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+
+	return withExecutor(clientset, config, node, namespace, "snapshot", func(e executor.Executor) error {
+		snap, err := claimSnapshot(e, volumeVG(pv, node), namespace, pvcName, name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Deleting snapshot %s of volume %s on node %s\n", snap.Path(), pvcName, node)
+		if !viper.GetBool("yes") {
+			if err := helper.Prompt("Do you want to proceed? (y/n) ", "y"); err != nil {
+				return err
+			}
+		}
+		stdout, stderr, err := e.Exec("lvremove -y "+snap.Path(), nil)
+		if err != nil {
+			return fmt.Errorf("unable to remove snapshot %s: %v %s %s", snap.Path(), err, stdout, stderr)
+		}
+		fmt.Printf("Snapshot %s deleted\n", name)
+		return nil
+	})
+}
+
+func restoreSnapshot(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("no pvc and snapshot given")
+	}
+	pvcName, name := args[0], args[1]
+	clientset, config, namespace, err := newClientset()
+	if err != nil {
+		return err
+	}
+	_, pv, node, err := claimVolume(clientset, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+	err = checkClaimUnused(clientset, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+
+	return withExecutor(clientset, config, node, namespace, "snapshot", func(e executor.Executor) error {
+		snap, err := claimSnapshot(e, volumeVG(pv, node), namespace, pvcName, name)
+		if err != nil {
+			return err
+		}
+		if snap.Origin != volumeLVName(pv) {
+			return fmt.Errorf("snapshot %s was taken of %s, but pvc %s is bound to %s", snap.Path(), snap.Origin, pvcName, volumeLVName(pv))
+		}
+		if snap.IsInvalid() {
+			return fmt.Errorf("snapshot %s ran out of space and is invalid", snap.Path())
+		}
+		origin, err := lvm.GetLV(e, snap.VG, snap.Origin)
+		if err != nil {
+			return err
+		}
+		if origin != nil && origin.IsOpen() {
+			return fmt.Errorf("logical volume %s is still open, e.g. mounted", origin.Path())
+		}
+
+		fmt.Printf("Restoring volume %s (%s/%s) on node %s to snapshot %s taken %s ago, all later changes are lost\n", pvcName, snap.VG, snap.Origin, node, snap.Name, age(snap.Time))
+		if !viper.GetBool("yes") {
+			if err := helper.Prompt("Do you want to proceed? (y/n) ", "y"); err != nil {
+				return err
+			}
+		}
+		fmt.Println("Please wait ...")
+		stdout, stderr, err := e.Exec("lvconvert -y --merge "+snap.Path(), nil)
+		if err != nil {
+			return fmt.Errorf("unable to merge snapshot %s: %v %s %s", snap.Path(), err, stdout, stderr)
+		}
+		fmt.Printf("Volume %s successfully restored. You can start your pod again.\n", pvcName)
+		return nil
+	})
+}
+
+// claimSnapshot returns the snapshot with the given name taken of the claim
+func claimSnapshot(e executor.Executor, vgname string, namespace string, pvcName string, name string) (*lvm.LogicalVolume, error) {
+	snap, err := lvm.GetLV(e, vgname, name)
+	if err != nil {
+		return nil, err
+	}
+	if snap == nil || !snap.IsSnapshot() {
+		return nil, fmt.Errorf("snapshot %s not found in volume group %s", name, vgname)
+	}
+	if !snap.HasTag(lvm.ClaimTag(namespace, pvcName)) {
+		return nil, fmt.Errorf("snapshot %s was not taken of pvc %s", name, pvcName)
+	}
+	return snap, nil
+}
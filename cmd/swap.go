@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+	"github.com/metal-stack/csilvmctl/cmd/internal/helper"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// volumeSwap rebinds a claim to a new csi-driver-lvm persistent volume which
+// is backed by the logical volume of the old one. The claim is recreated with
+// the storage class, the dummy logical volume provisioned for it is replaced
+// by the renamed old logical volume and the old persistent volume is removed.
+// The dummy logical volume is tagged temporary until it is replaced, the
+// renamed volume keeps its own tags.
+type volumeSwap struct {
+	clientset *kubernetes.Clientset
+	executor  executor.Executor
+	namespace string
+	node      string
+	vg        string
+	// storageClass of the new claim
+	storageClass string
+	// size is the storage request of the new claim, the logical volume must already have this size
+	size string
+	// beforeRename is called before the dummy logical volume is removed
+	beforeRename func() error
+	// afterRename is called with the new name of the old logical volume
+	afterRename func(lvName string) error
+}
+
+// run swaps the volume of the claim, whose logical volume has the given name, and returns the new claim
+func (s *volumeSwap) run(pvc *v1.PersistentVolumeClaim, lvName string) (*v1.PersistentVolumeClaim, error) {
+	pvcName := pvc.Name
+	oldVolumeName := pvc.Spec.VolumeName
+	pvcs := s.clientset.CoreV1().PersistentVolumeClaims(s.namespace)
+
+	// set volume to retain
+	err := setVolumeToRetain(s.clientset, oldVolumeName)
+	if err != nil {
+		return nil, err
+	}
+
+	// delete old pvc
+	err = pvcs.Delete(context.TODO(), pvcName, metav1.DeleteOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot remove pvc %s: %s", pvcName, err)
+	}
+
+	// wait till pvc is gone
+	retrySeconds := 60
+	for i := 0; i < retrySeconds; i++ {
+		tp, err := pvcs.Get(context.TODO(), pvcName, metav1.GetOptions{})
+		if tp != nil && tp.ObjectMeta.Name != pvcName {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error getting pvc %v: %v", pvcName, err)
+		}
+		time.Sleep(1 * time.Second)
+	}
+
+	// create new pvc
+	_, err = pvcs.Create(context.TODO(), &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   pvcName,
+			Labels: pvc.Labels,
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			StorageClassName: &s.storageClass,
+			VolumeMode:       pvc.Spec.VolumeMode,
+			AccessModes: []v1.PersistentVolumeAccessMode{
+				v1.ReadWriteOnce,
+			},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Mi"),
+				},
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the new pvc: %s", err)
+	}
+
+	// create dummy pod so that the lvm volume gets actually crated on the target node
+	tempMountPodName := helper.MounterPodPrefix + pvcName
+	err = startMounterPod(s.clientset, s.node, s.namespace, tempMountPodName, pvcName)
+	if err != nil {
+		return nil, err
+	}
+
+	// get new pv name
+	newPVC, err := pvcs.Get(context.TODO(), pvcName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	newVolume, err := s.clientset.CoreV1().PersistentVolumes().Get(context.TODO(), newPVC.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("new volume %s not found: %s", newPVC.Spec.VolumeName, err)
+	}
+	newLVName := volumeLVName(newVolume)
+
+	// tag the dummy volume, so cleanup finds it if we are interrupted before it is replaced
+	stdout, stderr, err := s.executor.Exec("lvchange --addtag "+lvm.TemporaryTag+" "+s.vg+"/"+newLVName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to tag dummy volume %s: %s %s %s", newLVName, err, stdout, stderr)
+	}
+
+	// delete dummy pod
+	err = helper.DestroyPodAndWait(s.clientset, s.namespace, tempMountPodName)
+	if err != nil {
+		return nil, err
+	}
+
+	// move volume
+	// lvremove -y newVolume
+	// lvrename oldVolume newVolume
+	if s.beforeRename != nil {
+		err = s.beforeRename()
+		if err != nil {
+			return nil, err
+		}
+	}
+	stdout, stderr, err = s.executor.Exec("lvremove -y "+s.vg+"/"+newLVName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to remove dummy volume %s: %s %s %s", newLVName, err, stdout, stderr)
+	}
+	stdout, stderr, err = s.executor.Exec("lvrename "+s.vg+"/"+lvName+" "+s.vg+"/"+newLVName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to rename volume %s to %s: %s %s %s", lvName, newLVName, err, stdout, stderr)
+	}
+	if s.afterRename != nil {
+		err = s.afterRename(newLVName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.clientset.CoreV1().PersistentVolumes().Delete(context.TODO(), oldVolumeName, metav1.DeleteOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable remove old pventry %s: %s", oldVolumeName, err)
+	}
+
+	// resize volumeclaim (volume itself already has the correct size), mount again to enforce resize
+	err = updateVolumeSize(s.clientset, s.namespace, pvcName, s.size)
+	if err != nil {
+		return nil, err
+	}
+	return newPVC, nil
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+
+	"github.com/spf13/viper"
+)
+
+// thinPool is the usage of a thin pool, sizes are in bytes
+type thinPool struct {
+	Name            string  `json:"name"`
+	Size            uint64  `json:"size"`
+	DataPercent     float64 `json:"dataPercent"`
+	MetadataPercent float64 `json:"metadataPercent"`
+	// Virtual is the sum of the sizes of the thin volumes in the pool
+	Virtual uint64 `json:"virtual"`
+	Volumes int    `json:"volumes"`
+}
+
+// thinPools returns the thin pools of the logical volumes with the size of their thin volumes
+func thinPools(lvs []lvm.LogicalVolume) []thinPool {
+	var pools []thinPool
+	for _, lv := range lvs {
+		if !lv.IsThinPool() {
+			continue
+		}
+		p := thinPool{
+			Name:            lv.Name,
+			Size:            lv.Size,
+			DataPercent:     lv.DataPercent,
+			MetadataPercent: lv.MetadataPercent,
+		}
+		for _, t := range lvs {
+			if t.IsThin() && t.PoolLV == lv.Name {
+				p.Virtual += t.Size
+				p.Volumes++
+			}
+		}
+		pools = append(pools, p)
+	}
+	return pools
+}
+
+// warning returns why new allocations in the pool are risky or an empty string
+func (p *thinPool) warning() string {
+	limit := viper.GetFloat64("thin-pool-warn-percent")
+	var reasons []string
+	if p.DataPercent >= limit {
+		reasons = append(reasons, fmt.Sprintf("data is %.1f%% used", p.DataPercent))
+	}
+	if p.MetadataPercent >= limit {
+		reasons = append(reasons, fmt.Sprintf("metadata is %.1f%% used", p.MetadataPercent))
+	}
+	// an overcommitted pool runs full once the volumes are written, regardless of its current usage
+	if p.Virtual > p.Size {
+		reasons = append(reasons, fmt.Sprintf("%s of thin volumes are overcommitted to %s", formatBytes(p.Virtual), formatBytes(p.Size)))
+	}
+	if len(reasons) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("thin pool %s: %s, volumes stop when it runs full", p.Name, strings.Join(reasons, ", "))
+}
+
+// thinPoolWarning returns the warning about the pool of a thin volume, it is empty for other volumes
+func thinPoolWarning(e executor.Executor, lv *lvm.LogicalVolume) (string, error) {
+	if !lv.IsThin() {
+		return "", nil
+	}
+	lvs, err := lvm.ListLVs(e, lv.VG)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range thinPools(lvs) {
+		if p.Name == lv.PoolLV {
+			return p.warning(), nil
+		}
+	}
+	return "", fmt.Errorf("thin pool %s of %s not found", lv.PoolLV, lv.Path())
+}
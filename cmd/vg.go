@@ -0,0 +1,431 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+	"github.com/metal-stack/csilvmctl/cmd/internal/helper"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	vgCmd = &cobra.Command{
+		Use:   "vg",
+		Short: "manage the volume group of a node",
+	}
+	vgExtendCmd = &cobra.Command{
+		Use:   "extend",
+		Short: "add unused disks to the volume group of a node",
+		Long: "find block devices matching the pattern which are unpartitioned, carry no filesystem or partition table signature " +
+			"and are no physical volumes yet, and add them to the csi-driver-lvm volume group of the node with pvcreate and vgextend. " +
+			"The node name has to be typed to proceed, --yes does not skip this confirmation.",
+		PreRun: bindPFlags,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return extendVG()
+		},
+	}
+	vgEvacuateCmd = &cobra.Command{
+		Use:   "evacuate",
+		Short: "move all extents off a physical volume and remove it from its volume group",
+		Long: "check that the remaining physical volumes of the volume group have enough free space, show the logical volumes " +
+			"with extents on the device together with their claims and workloads, move the extents with pvmove and remove the device " +
+			"from the volume group with vgreduce. Volumes stay online while they are moved. An interrupted pvmove is continued.",
+		PreRun: bindPFlags,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return evacuatePV()
+		},
+	}
+)
+
+func init() {
+	vgExtendCmd.Flags().String("node", "", "node of the volume group")
+	vgExtendCmd.Flags().String("device-pattern", "", "shell pattern of the devices to add, e.g. /dev/nvme[0-9]n1")
+	vgEvacuateCmd.Flags().String("node", "", "node of the volume group")
+	vgEvacuateCmd.Flags().String("device", "", "physical volume to evacuate, e.g. /dev/nvme1n1")
+	vgEvacuateCmd.Flags().Duration("interval", 10*time.Second, "interval for reporting the pvmove progress")
+	vgCmd.AddCommand(vgExtendCmd)
+	vgCmd.AddCommand(vgEvacuateCmd)
+}
+
+// devicePatternFormat restricts device patterns to paths below /dev with shell wildcards
+var devicePatternFormat = regexp.MustCompile(`^/dev/[A-Za-z0-9_./*?\[\]-]+$`)
+
+// blockDevice is a block device of a node matching a device pattern
+type blockDevice struct {
+	path string
+	// size in bytes
+	size uint64
+	// reason why the device cannot be added, empty for candidates
+	reason string
+}
+
+func extendVG() error {
+	node := viper.GetString("node")
+	if node == "" {
+		return fmt.Errorf("no node given")
+	}
+	pattern := viper.GetString("device-pattern")
+	if pattern == "" {
+		return fmt.Errorf("no device pattern given")
+	}
+	if !devicePatternFormat.MatchString(pattern) {
+		return fmt.Errorf("invalid device pattern %q, must be a path below /dev with the wildcards *, ? and []", pattern)
+	}
+
+	// the local executor extends the volume group without the API server
+	clientset, config, namespace, err := newClientset()
+	if err != nil && !apiUnavailable(err) {
+		return err
+	}
+	return withExecutor(clientset, config, node, namespace, "vg", func(e executor.Executor) error {
+		vgname := driverVG(node)
+		vg, err := lvm.GetVG(e, vgname)
+		if err != nil {
+			return err
+		}
+		if vg == nil {
+			return fmt.Errorf("volume group %s not found on node %s", vgname, node)
+		}
+		devices, err := matchingDevices(e, pattern)
+		if err != nil {
+			return err
+		}
+		if len(devices) == 0 {
+			return fmt.Errorf("no block devices matching %s found on node %s", pattern, node)
+		}
+
+		var candidates []blockDevice
+		var added uint64
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "DEVICE\tSIZE\tSTATUS")
+		for _, d := range devices {
+			status := d.reason
+			if status == "" {
+				status = "will be added"
+				candidates = append(candidates, d)
+				added += d.size
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", d.path, formatBytes(d.size), status)
+		}
+		w.Flush()
+		if len(candidates) == 0 {
+			return fmt.Errorf("none of the devices matching %s can be added to volume group %s", pattern, vgname)
+		}
+
+		fmt.Printf("Volume group %s on node %s will grow from %s (%s free) to %s (%s free)\n",
+			vgname, node, formatBytes(vg.Size), formatBytes(vg.Free), formatBytes(vg.Size+added), formatBytes(vg.Free+added))
+		fmt.Println("All data on the added devices is lost.")
+		if err := helper.Prompt("Type the node name \""+node+"\" to proceed: ", node); err != nil {
+			return err
+		}
+
+		var paths []string
+		for _, d := range candidates {
+			paths = append(paths, d.path)
+		}
+		stdout, stderr, err := e.Exec("pvcreate "+strings.Join(paths, " "), nil)
+		if err != nil {
+			return fmt.Errorf("unable to create physical volumes on %v: %v %s %s", paths, err, stdout, stderr)
+		}
+		stdout, stderr, err = e.Exec("vgextend "+vgname+" "+strings.Join(paths, " "), nil)
+		if err != nil {
+			return fmt.Errorf("unable to extend volume group %s with %v: %v %s %s", vgname, paths, err, stdout, stderr)
+		}
+		vg, err = lvm.GetVG(e, vgname)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Volume group %s on node %s extended with %v, size %s, free %s\n", vgname, node, paths, formatBytes(vg.Size), formatBytes(vg.Free))
+		return nil
+	})
+}
+
+// matchingDevices returns the block devices matching the pattern together
+// with the reason why they cannot become physical volumes
+func matchingDevices(e executor.Executor, pattern string) ([]blockDevice, error) {
+	// per device: path, partition flag, number of partitions, holders, read-only flag, size and signatures found by blkid
+	script := "for d in " + pattern + "; do [ -b \"$d\" ] || continue; " +
+		"n=$(basename $(readlink -f \"$d\")); s=/sys/class/block/$n; " +
+		"echo \"$d|$([ -e $s/partition ] && echo 1)|$(ls -d $s/$n* 2>/dev/null | wc -l)|$(ls $s/holders | wc -l)|$(cat $s/ro)|" +
+		"$(blockdev --getsize64 \"$d\")|$(blkid -p -o value -s TYPE -s PTTYPE \"$d\" 2>/dev/null | tr '\\n' ' ')\"; done"
+	stdout, stderr, err := e.Exec(script, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list devices matching %s: %v %s %s", pattern, err, stdout, stderr)
+	}
+	pvs, err := lvm.ListPVs(e)
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []blockDevice
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "|")
+		if len(fields) != 7 {
+			continue
+		}
+		size, _ := strconv.ParseUint(fields[5], 10, 64)
+		d := blockDevice{path: fields[0], size: size}
+		signatures := strings.TrimSpace(fields[6])
+		switch {
+		case fields[1] == "1":
+			d.reason = "is a partition"
+		case fields[2] != "0":
+			d.reason = "has partitions"
+		case fields[4] == "1":
+			d.reason = "is read-only"
+		case isPV(pvs, d.path):
+			d.reason = "is already a physical volume"
+		case signatures != "":
+			d.reason = "has signature " + signatures
+		case fields[3] != "0":
+			d.reason = "is in use"
+		case size == 0:
+			d.reason = "is empty"
+		}
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+// isPV returns true if the device is a physical volume
+func isPV(pvs []lvm.PhysicalVolume, device string) bool {
+	for _, pv := range pvs {
+		if pv.Name == device {
+			return true
+		}
+	}
+	return false
+}
+
+// affectedVolume is a logical volume with extents on an evacuated physical volume
+type affectedVolume struct {
+	lv lvm.LogicalVolume
+	// size of the extents on the physical volume in bytes
+	size uint64
+	// claim is namespace/name of the claim of the volume, empty if there is none
+	claim     string
+	workloads []workload
+}
+
+func evacuatePV() error {
+	node := viper.GetString("node")
+	if node == "" {
+		return fmt.Errorf("no node given")
+	}
+	device := viper.GetString("device")
+	if device == "" {
+		return fmt.Errorf("no device given")
+	}
+
+	clientset, config, namespace, err := newClientset()
+	if err != nil {
+		return err
+	}
+	volumes, err := lvmVolumes(clientset)
+	if err != nil {
+		return err
+	}
+	return withExecutor(clientset, config, node, namespace, "vg", func(e executor.Executor) error {
+		physical, err := lvm.ListPVs(e)
+		if err != nil {
+			return err
+		}
+		var pv *lvm.PhysicalVolume
+		for i := range physical {
+			if physical[i].Name == device {
+				pv = &physical[i]
+			}
+		}
+		if pv == nil {
+			return fmt.Errorf("device %s is no physical volume on node %s", device, node)
+		}
+		vgname := pv.VG
+		if !contains(nodeVGs(node), vgname) {
+			return fmt.Errorf("device %s belongs to volume group %q, not to csi-driver-lvm or csi-lvm on node %s", device, vgname, node)
+		}
+		vg, err := lvm.GetVG(e, vgname)
+		if err != nil {
+			return err
+		}
+		if vg == nil {
+			return fmt.Errorf("volume group %s not found on node %s", vgname, node)
+		}
+		if vg.PVCount < 2 {
+			return fmt.Errorf("device %s is the only physical volume of volume group %s", device, vgname)
+		}
+		segments, err := lvm.ListSegments(e, vgname)
+		if err != nil {
+			return err
+		}
+		lvs, err := lvm.ListLVs(e, vgname)
+		if err != nil {
+			return err
+		}
+
+		// a pvmove in progress shows up as hidden pvmove volume
+		resume := false
+		for _, s := range segments {
+			if strings.HasPrefix(s.LV, "pvmove") {
+				resume = true
+			}
+		}
+		var affected []affectedVolume
+		if !resume {
+			affected, err = checkEvacuation(physical, segments, lvs, vg, device)
+			if err != nil {
+				return err
+			}
+		}
+		for i := range affected {
+			err = affectedClaim(clientset, &affected[i], volumes, node)
+			if err != nil {
+				return err
+			}
+		}
+
+		if resume {
+			fmt.Printf("A pvmove is in progress in volume group %s on node %s, it will be continued\n", vgname, node)
+		} else {
+			printAffectedVolumes(affected)
+			fmt.Printf("%s of %s will be moved to the other physical volumes of volume group %s on node %s\n", formatBytes(pv.Size-pv.Free), device, vgname, node)
+		}
+		fmt.Printf("Afterwards %s is removed from volume group %s\n", device, vgname)
+		if !viper.GetBool("yes") {
+			if err := helper.Prompt("Do you want to proceed? (y/n) ", "y"); err != nil {
+				return err
+			}
+		}
+
+		interval := strconv.Itoa(int(viper.GetDuration("interval").Seconds()))
+		command := "pvmove -i " + interval + " " + device
+		if resume {
+			command = "pvmove -i " + interval
+		}
+		if pv.Size > pv.Free || resume {
+			stderr, err := e.Stream(command, nil, os.Stdout)
+			if err != nil {
+				return fmt.Errorf("unable to move extents off %s: %v %s", device, err, stderr)
+			}
+		}
+		stdout, stderr, err := e.Exec("vgreduce "+vgname+" "+device, nil)
+		if err != nil {
+			return fmt.Errorf("unable to remove %s from volume group %s: %v %s %s", device, vgname, err, stdout, stderr)
+		}
+
+		fmt.Printf("Device %s evacuated and removed from volume group %s on node %s\n", device, vgname, node)
+		for _, a := range affected {
+			for _, w := range a.workloads {
+				fmt.Printf("  %s was moved while used by %s\n", a.claim, w)
+			}
+		}
+		return nil
+	})
+}
+
+// checkEvacuation returns the volumes with extents on the device and checks
+// that they fit on the remaining allocatable physical volumes. Legs of mirrors
+// and stripes must stay on separate physical volumes, so only physical volumes
+// not holding the volume already count for them.
+func checkEvacuation(physical []lvm.PhysicalVolume, segments []lvm.Segment, lvs []lvm.LogicalVolume, vg *lvm.VolumeGroup, device string) ([]affectedVolume, error) {
+	extents := map[string]uint64{}
+	holders := map[string]map[string]bool{}
+	for _, s := range segments {
+		if s.LV == "" {
+			continue
+		}
+		if s.PV == device {
+			extents[s.LV] += s.Extents
+		}
+		if holders[s.LV] == nil {
+			holders[s.LV] = map[string]bool{}
+		}
+		holders[s.LV][s.PV] = true
+	}
+
+	free := map[string]uint64{}
+	var totalFree, needed uint64
+	for _, pv := range physical {
+		if pv.VG == vg.Name && pv.Name != device && pv.IsAllocatable() && !pv.IsMissing() {
+			free[pv.Name] = pv.Free
+			totalFree += pv.Free
+		}
+	}
+	var affected []affectedVolume
+	for _, lv := range lvs {
+		n, ok := extents[lv.Name]
+		if !ok {
+			continue
+		}
+		a := affectedVolume{lv: lv, size: n * vg.ExtentSize}
+		needed += a.size
+		if t := lv.Type(); t == "mirror" || t == "striped" {
+			var separate uint64
+			for pv, f := range free {
+				if !holders[lv.Name][pv] {
+					separate += f
+				}
+			}
+			if separate < a.size {
+				return nil, fmt.Errorf("%s volume %s needs %s on physical volumes not holding it already, only %s free", t, lv.Path(), formatBytes(a.size), formatBytes(separate))
+			}
+		}
+		affected = append(affected, a)
+	}
+	if totalFree < needed {
+		return nil, fmt.Errorf("%s of %s are used, the remaining physical volumes of %s have only %s free", formatBytes(needed), device, vg.Name, formatBytes(totalFree))
+	}
+	// claims are on the thin volumes of an affected pool
+	for _, lv := range lvs {
+		if lv.IsThin() && extents[lv.PoolLV] > 0 {
+			affected = append(affected, affectedVolume{lv: lv})
+		}
+	}
+	return affected, nil
+}
+
+// affectedClaim looks up the claim of the affected volume and the workloads using it
+func affectedClaim(clientset *kubernetes.Clientset, a *affectedVolume, volumes []v1.PersistentVolume, node string) error {
+	pv := findVolume(volumes, node, a.lv.Name)
+	if pv == nil || pv.Spec.ClaimRef == nil {
+		return nil
+	}
+	a.claim = pv.Spec.ClaimRef.Namespace + "/" + pv.Spec.ClaimRef.Name
+	var err error
+	a.workloads, err = claimWorkloads(clientset, pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name)
+	return err
+}
+
+func printAffectedVolumes(affected []affectedVolume) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "LV\tTYPE\tON DEVICE\tCLAIM\tWORKLOADS")
+	for _, a := range affected {
+		size, claim, workloads := "-", "-", "-"
+		if a.size > 0 {
+			size = formatBytes(a.size)
+		}
+		if a.claim != "" {
+			claim = a.claim
+		}
+		if len(a.workloads) > 0 {
+			var names []string
+			for _, w := range a.workloads {
+				names = append(names, w.kind+"/"+w.name)
+			}
+			workloads = strings.Join(names, ",")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", a.lv.Path(), a.lv.Type(), size, claim, workloads)
+	}
+	w.Flush()
+}
@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/metal-stack/csilvmctl/cmd/internal/executor"
+	"github.com/metal-stack/csilvmctl/cmd/internal/lvm"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/spf13/viper"
+)
+
+// annotations or labels of nodes naming their volume groups
+const (
+	vgNameAnnotation       = "csilvmctl.metal-stack.io/vgname"
+	legacyVGNameAnnotation = "csilvmctl.metal-stack.io/legacy-vgname"
+)
+
+// defaultVGName is used if the volume group of a node cannot be resolved otherwise
+const defaultVGName = "csi-lvm"
+
+// nodeVolumeGroups are the volume groups of csi-driver-lvm and csi-lvm on a node
+type nodeVolumeGroups struct {
+	driver string
+	legacy string
+}
+
+// resolvedVGs holds the volume groups per node, they are resolved when the
+// first executor on the node is started
+var resolvedVGs = map[string]nodeVolumeGroups{}
+
+// resolveVGs determines the volume groups of the node. The csi-driver-lvm
+// volume group is taken from, in this order, the vgname flag, the vgnames map
+// of the config file, the vgname annotation or label of the node, the volume
+// group tagged by csi-driver-lvm or the one holding its logical volumes. The
+// csi-lvm volume group is resolved the same way with the legacy settings and
+// the volume group holding csi-lvm logical volumes, it defaults to the
+// csi-driver-lvm volume group.
+func resolveVGs(clientset *kubernetes.Clientset, e executor.Executor, node string) error {
+	if _, ok := resolvedVGs[node]; ok {
+		return nil
+	}
+	driver := configuredVG(node, "vgname", "vgnames")
+	legacy := configuredVG(node, "legacy-vgname", "legacy-vgnames")
+	// without API server the node cannot be looked up
+	if (driver == "" || legacy == "") && clientset != nil {
+		n, err := clientset.CoreV1().Nodes().Get(context.TODO(), node, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			if driver == "" {
+				driver = nodeVGAnnotation(n, vgNameAnnotation)
+			}
+			if legacy == "" {
+				legacy = nodeVGAnnotation(n, legacyVGNameAnnotation)
+			}
+		case viper.GetString("executor") == executorLocal:
+			// the local executor is used when the API server is degraded, the
+			// volume groups are discovered on the host instead
+			fmt.Fprintf(os.Stderr, "unable to get node %s, discovering its volume groups locally: %v\n", node, err)
+		default:
+			return fmt.Errorf("unable to get node %s: %v", node, err)
+		}
+	}
+	if driver == "" || legacy == "" {
+		discoveredDriver, discoveredLegacy, err := discoverVGs(e)
+		if err != nil {
+			return fmt.Errorf("unable to discover volume groups on node %s: %v", node, err)
+		}
+		if driver == "" {
+			driver = discoveredDriver
+		}
+		if legacy == "" {
+			legacy = discoveredLegacy
+		}
+	}
+	if driver == "" {
+		driver = defaultVGName
+	}
+	if legacy == "" {
+		legacy = driver
+	}
+	resolvedVGs[node] = nodeVolumeGroups{driver: driver, legacy: legacy}
+	return nil
+}
+
+// configuredVG returns the volume group given by flag or in the per node map of the config file
+func configuredVG(node string, key string, mapKey string) string {
+	if vg := viper.GetString(key); vg != "" {
+		return vg
+	}
+	// viper lowercases the keys of maps
+	return viper.GetStringMapString(mapKey)[node]
+}
+
+func nodeVGAnnotation(n *v1.Node, key string) string {
+	if vg := n.Annotations[key]; vg != "" {
+		return vg
+	}
+	return n.Labels[key]
+}
+
+// discoverVGs returns the volume groups holding the logical volumes of csi-driver-lvm and csi-lvm
+func discoverVGs(e executor.Executor) (string, string, error) {
+	vgs, err := lvm.ListVGs(e)
+	if err != nil {
+		return "", "", err
+	}
+	lvs, err := lvm.ListLVs(e, "")
+	if err != nil {
+		return "", "", err
+	}
+	driver := map[string]bool{}
+	for _, vg := range vgs {
+		if vg.HasTag(lvm.DriverTag) {
+			driver[vg.Name] = true
+		}
+	}
+	legacy := map[string]bool{}
+	for _, lv := range lvs {
+		if lv.HasTag(lvm.DriverTag) && len(driver) == 0 {
+			driver[lv.VG] = true
+		}
+		if lv.HasTag(lvm.LegacyTag) {
+			legacy[lv.VG] = true
+		}
+	}
+	driverVG, err := singleVG(driver, "csi-driver-lvm")
+	if err != nil {
+		return "", "", err
+	}
+	legacyVG, err := singleVG(legacy, "csi-lvm")
+	if err != nil {
+		return "", "", err
+	}
+	return driverVG, legacyVG, nil
+}
+
+func singleVG(vgs map[string]bool, driver string) (string, error) {
+	var names []string
+	for name := range vgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > 1 {
+		return "", fmt.Errorf("%s volumes found in the volume groups %v, configure the volume group of the node", driver, names)
+	}
+	if len(names) == 1 {
+		return names[0], nil
+	}
+	return "", nil
+}
+
+// driverVG returns the csi-driver-lvm volume group of the node
+func driverVG(node string) string {
+	if vgs, ok := resolvedVGs[node]; ok {
+		return vgs.driver
+	}
+	return defaultVGName
+}
+
+// legacyVG returns the csi-lvm volume group of the node
+func legacyVG(node string) string {
+	if vgs, ok := resolvedVGs[node]; ok {
+		return vgs.legacy
+	}
+	return driverVG(node)
+}
+
+// volumeVG returns the volume group of the persistent volume on its node
+func volumeVG(pv *v1.PersistentVolume, node string) string {
+	if pv.Annotations[provisionedByAnnotation] == legacyProvisioner {
+		return legacyVG(node)
+	}
+	return driverVG(node)
+}
+
+// nodeVGs returns the distinct volume groups of csi-driver-lvm and csi-lvm on the node
+func nodeVGs(node string) []string {
+	if legacyVG(node) == driverVG(node) {
+		return []string{driverVG(node)}
+	}
+	return []string{driverVG(node), legacyVG(node)}
+}
+
+// listNodeLVs returns the logical volumes of all volume groups of csi-driver-lvm and csi-lvm on the node
+func listNodeLVs(e executor.Executor, node string) ([]lvm.LogicalVolume, error) {
+	var result []lvm.LogicalVolume
+	for _, vg := range nodeVGs(node) {
+		lvs, err := lvm.ListLVs(e, vg)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, lvs...)
+	}
+	return result, nil
+}
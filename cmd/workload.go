@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// workload is the top level owner of pods, e.g. a Deployment, or the pod itself if it has no owner
+type workload struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+func (w workload) String() string {
+	return w.namespace + "/" + w.kind + "/" + w.name
+}
+
+// claimWorkloads returns the workloads of the pods using the claim
+func claimWorkloads(clientset *kubernetes.Clientset, namespace string, pvcName string) ([]workload, error) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	seen := map[workload]bool{}
+	var result []workload
+	for _, p := range pods.Items {
+		for _, v := range p.Spec.Volumes {
+			if v.PersistentVolumeClaim == nil || v.PersistentVolumeClaim.ClaimName != pvcName {
+				continue
+			}
+			w, err := ownerWorkload(clientset, namespace, "Pod", p.Name, p.OwnerReferences)
+			if err != nil {
+				return nil, err
+			}
+			if !seen[w] {
+				seen[w] = true
+				result = append(result, w)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].String() < result[j].String() })
+	return result, nil
+}
+
+// ownerWorkload follows the controller references of an object up to its top level owner,
+// ReplicaSets are resolved to their Deployment
+func ownerWorkload(clientset *kubernetes.Clientset, namespace string, kind string, name string, owners []metav1.OwnerReference) (workload, error) {
+	for _, o := range owners {
+		if o.Controller == nil || !*o.Controller {
+			continue
+		}
+		if o.Kind == "ReplicaSet" {
+			rs, err := clientset.AppsV1().ReplicaSets(namespace).Get(context.TODO(), o.Name, metav1.GetOptions{})
+			if err != nil {
+				return workload{}, fmt.Errorf("unable to get replicaset %s: %v", o.Name, err)
+			}
+			return ownerWorkload(clientset, namespace, o.Kind, o.Name, rs.OwnerReferences)
+		}
+		return workload{kind: o.Kind, namespace: namespace, name: o.Name}, nil
+	}
+	return workload{kind: kind, namespace: namespace, name: name}, nil
+}
+
+// scaleClient returns the functions to get and update the scale of the workload,
+// nil if it cannot be scaled
+func scaleClient(clientset *kubernetes.Clientset, w workload) (func() (*autoscalingv1.Scale, error), func(*autoscalingv1.Scale) error) {
+	apps := clientset.AppsV1()
+	switch w.kind {
+	case "Deployment":
+		return func() (*autoscalingv1.Scale, error) {
+				return apps.Deployments(w.namespace).GetScale(context.TODO(), w.name, metav1.GetOptions{})
+			}, func(s *autoscalingv1.Scale) error {
+				_, err := apps.Deployments(w.namespace).UpdateScale(context.TODO(), w.name, s, metav1.UpdateOptions{})
+				return err
+			}
+	case "StatefulSet":
+		return func() (*autoscalingv1.Scale, error) {
+				return apps.StatefulSets(w.namespace).GetScale(context.TODO(), w.name, metav1.GetOptions{})
+			}, func(s *autoscalingv1.Scale) error {
+				_, err := apps.StatefulSets(w.namespace).UpdateScale(context.TODO(), w.name, s, metav1.UpdateOptions{})
+				return err
+			}
+	case "ReplicaSet":
+		return func() (*autoscalingv1.Scale, error) {
+				return apps.ReplicaSets(w.namespace).GetScale(context.TODO(), w.name, metav1.GetOptions{})
+			}, func(s *autoscalingv1.Scale) error {
+				_, err := apps.ReplicaSets(w.namespace).UpdateScale(context.TODO(), w.name, s, metav1.UpdateOptions{})
+				return err
+			}
+	}
+	return nil, nil
+}
+
+// workloadReplicas returns the number of replicas the workload is scaled to
+func workloadReplicas(clientset *kubernetes.Clientset, w workload) (int32, error) {
+	get, _ := scaleClient(clientset, w)
+	if get == nil {
+		return 0, fmt.Errorf("%s cannot be scaled", w)
+	}
+	s, err := get()
+	if err != nil {
+		return 0, fmt.Errorf("unable to get scale of %s: %v", w, err)
+	}
+	return s.Spec.Replicas, nil
+}
+
+// scaleWorkload scales the workload to the given number of replicas
+func scaleWorkload(clientset *kubernetes.Clientset, w workload, replicas int32) error {
+	get, update := scaleClient(clientset, w)
+	if get == nil {
+		return fmt.Errorf("%s cannot be scaled", w)
+	}
+	s, err := get()
+	if err != nil {
+		return fmt.Errorf("unable to get scale of %s: %v", w, err)
+	}
+	s.Spec.Replicas = replicas
+	err = update(s)
+	if err != nil {
+		return fmt.Errorf("unable to scale %s to %d replicas: %v", w, replicas, err)
+	}
+	return nil
+}